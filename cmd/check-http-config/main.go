@@ -0,0 +1,269 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The check-http-config command checks an HTTP client configuration file
+// before it is rolled out: it validates the configuration, checks that the
+// CA, client cert and client key parse and that the cert and key match, and
+// reports the expiry dates of the certificates. Given a URL, it also sends a
+// request with the configuration to check the proxy and TLS settings.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/version"
+)
+
+// checkOptions are the settings of a check.
+type checkOptions struct {
+	// url, if not empty, is requested with the configuration.
+	url string
+	// timeout is the timeout of the request to url.
+	timeout time.Duration
+	// expandEnv enables the expansion of the ${VAR} references in the
+	// configuration file.
+	expandEnv bool
+	// expiryWarning is the duration before their expiry from which the
+	// certificates are reported as expiring.
+	expiryWarning time.Duration
+	now           func() time.Time
+}
+
+func main() {
+	app := kingpin.New(filepath.Base(os.Args[0]), "Checks an HTTP client configuration file.")
+	app.Version(version.Print("check-http-config"))
+	app.HelpFlag.Short('h')
+
+	o := checkOptions{now: time.Now}
+	filename := app.Arg("config.file", "The YAML file holding the HTTP client configuration.").Required().String()
+	app.Flag("url", "URL to request with the configuration to check the proxy and TLS settings.").StringVar(&o.url)
+	app.Flag("timeout", "Timeout of the request to --url.").Default("10s").DurationVar(&o.timeout)
+	app.Flag("expand-env", "Expand the ${VAR} references to environment variables in the configuration file.").BoolVar(&o.expandEnv)
+	app.Flag("expiry-warning", "Warn about the certificates expiring within this duration.").Default("720h").DurationVar(&o.expiryWarning)
+	kingpin.MustParse(app.Parse(os.Args[1:]))
+
+	if err := check(os.Stdout, *filename, o); err != nil {
+		fmt.Fprintln(os.Stderr, "FAILED:", err)
+		os.Exit(1)
+	}
+}
+
+// check checks the configuration file, writing its findings to w. It fails
+// if the configuration can't be used or if a certificate isn't valid now.
+func check(w io.Writer, filename string, o checkOptions) error {
+	var loadOpts []config.LoadOption
+	if o.expandEnv {
+		loadOpts = append(loadOpts, config.WithEnvExpansion())
+	}
+	var cfg config.HTTPClientConfig
+	if err := config.LoadFile(filename, &cfg, loadOpts...); err != nil {
+		return fmt.Errorf("unable to load %s: %s", filename, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration %s: %s", filename, err)
+	}
+	fmt.Fprintf(w, "SUCCESS: %s is valid\n", filename)
+
+	tlsConfig, err := config.NewTLSConfig(&cfg.TLSConfig)
+	if err != nil {
+		return err
+	}
+	certs, err := tlsCertificates(&cfg.TLSConfig, tlsConfig)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, c := range certs {
+		if !reportExpiry(w, c.description, c.cert, o) {
+			failed++
+		}
+	}
+
+	if o.url != "" {
+		serverCerts, err := request(w, cfg, o)
+		if err != nil {
+			return err
+		}
+		for _, cert := range serverCerts {
+			if !reportExpiry(w, "server certificate", cert, o) {
+				failed++
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d certificate(s) not valid", failed)
+	}
+	return nil
+}
+
+// describedCertificate is a certificate and where it comes from.
+type describedCertificate struct {
+	description string
+	cert        *x509.Certificate
+}
+
+// tlsCertificates returns the CA certificates and the client certificates of
+// the configuration. The client certificates are those loaded by tlsConfig,
+// which checks that the client cert and key match and decrypts the key.
+func tlsCertificates(cfg *config.TLSConfig, tlsConfig *tls.Config) ([]describedCertificate, error) {
+	var certs []describedCertificate
+	if cfg.CA != "" || cfg.CAFile != "" {
+		b, err := readInlineOrFile(cfg.CA, cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA %s: %s", source(cfg.CA, cfg.CAFile), err)
+		}
+		ca, err := parseCertificates(b)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse CA %s: %s", source(cfg.CA, cfg.CAFile), err)
+		}
+		for _, cert := range ca {
+			certs = append(certs, describedCertificate{"CA certificate", cert})
+		}
+	}
+	if cfg.CADir != "" {
+		files, err := config.CADirFiles(cfg.CADir)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA dir %s: %s", cfg.CADir, err)
+		}
+		for _, f := range files {
+			b, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read CA %s: %s", f, err)
+			}
+			ca, err := parseCertificates(b)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse CA %s: %s", f, err)
+			}
+			for _, cert := range ca {
+				certs = append(certs, describedCertificate{"CA certificate", cert})
+			}
+		}
+	}
+
+	if tlsConfig.GetClientCertificate != nil {
+		pair, err := tlsConfig.GetClientCertificate(&tls.CertificateRequestInfo{})
+		if err != nil {
+			return nil, err
+		}
+		for _, der := range pair.Certificate {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse client cert %s: %s", source(cfg.Cert, cfg.CertFile), err)
+			}
+			certs = append(certs, describedCertificate{"client certificate", cert})
+		}
+	}
+	return certs, nil
+}
+
+// request sends a GET request to the URL with the configuration and returns
+// the certificates presented by the server, if any.
+func request(w io.Writer, cfg config.HTTPClientConfig, o checkOptions) ([]*x509.Certificate, error) {
+	client, err := config.NewClientFromConfigWithOptions(cfg, "check-http-config", config.WithKeepAlivesDisabled())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, o.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %s", o.url, err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	fmt.Fprintf(w, "SUCCESS: %s answered with status %q\n", o.url, resp.Status)
+	if resp.TLS == nil {
+		return nil, nil
+	}
+	fmt.Fprintf(w, "  TLS version %s, cipher suite %s\n", config.TLSVersion(resp.TLS.Version), config.TLSCipherSuite(resp.TLS.CipherSuite))
+	return resp.TLS.PeerCertificates, nil
+}
+
+// reportExpiry writes the validity period of the certificate to w, along
+// with a warning if it expires soon. It returns false if the certificate
+// isn't valid now.
+func reportExpiry(w io.Writer, description string, cert *x509.Certificate, o checkOptions) bool {
+	now := o.now()
+	fmt.Fprintf(w, "  %s %q: valid from %s until %s\n", description, cert.Subject.String(),
+		cert.NotBefore.UTC().Format(time.RFC3339), cert.NotAfter.UTC().Format(time.RFC3339))
+	switch {
+	case now.Before(cert.NotBefore):
+		fmt.Fprintf(w, "FAILED: %s %q is not valid yet\n", description, cert.Subject.String())
+		return false
+	case now.After(cert.NotAfter):
+		fmt.Fprintf(w, "FAILED: %s %q has expired\n", description, cert.Subject.String())
+		return false
+	case cert.NotAfter.Sub(now) < o.expiryWarning:
+		fmt.Fprintf(w, "WARNING: %s %q expires in %s\n", description, cert.Subject.String(), cert.NotAfter.Sub(now).Round(time.Minute))
+	}
+	return true
+}
+
+// parseCertificates parses the PEM encoded certificates of b.
+func parseCertificates(b []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return certs, nil
+}
+
+// readInlineOrFile returns the inline content or reads it from the file.
+func readInlineOrFile(inline, filename string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+	return ioutil.ReadFile(filename)
+}
+
+// source describes where a TLS item comes from for messages.
+func source(inline, filename string) string {
+	if inline != "" {
+		return "<inline>"
+	}
+	return filename
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	ca, err := ioutil.ReadFile("../../config/testdata/tls-ca-chain.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	cert, err := tls.LoadX509KeyPair("../../config/testdata/server.crt", "../../config/testdata/server.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	server.StartTLS()
+	return server
+}
+
+func TestCheck(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	testCases := []struct {
+		filename      string
+		url           string
+		now           time.Time
+		expiryWarning time.Duration
+		errMsg        string
+		output        []string
+	}{
+		{
+			filename: "testdata/good.yml",
+			url:      server.URL,
+			output: []string{
+				"SUCCESS: testdata/good.yml is valid",
+				`CA certificate "CN=Prometheus Root CA,OU=Prometheus Certificate Authority,O=Prometheus,C=US": valid from`,
+				`client certificate "CN=Client,O=Prometheus,C=US": valid from`,
+				`SUCCESS: ` + server.URL + ` answered with status "200 OK"`,
+				`server certificate "CN=prometheus.example.com,O=Prometheus,C=US": valid from`,
+				"TLS version TLS1",
+			},
+		}, {
+			filename:      "testdata/good.yml",
+			expiryWarning: 100 * 365 * 24 * time.Hour,
+			output: []string{
+				`WARNING: client certificate "CN=Client,O=Prometheus,C=US" expires in`,
+			},
+		}, {
+			filename: "testdata/good.yml",
+			now:      time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+			errMsg:   "3 certificate(s) not valid",
+			output: []string{
+				`FAILED: client certificate "CN=Client,O=Prometheus,C=US" has expired`,
+			},
+		}, {
+			filename: "testdata/good.yml",
+			now:      time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			errMsg:   "3 certificate(s) not valid",
+			output: []string{
+				`FAILED: client certificate "CN=Client,O=Prometheus,C=US" is not valid yet`,
+			},
+		}, {
+			filename: "testdata/ca-dir.yml",
+			output: []string{
+				`CA certificate "CN=Prometheus TLS CA,OU=Prometheus Certificate Authority,O=Prometheus,C=US": valid from`,
+				`CA certificate "CN=Prometheus Root CA,OU=Prometheus Certificate Authority,O=Prometheus,C=US": valid from`,
+			},
+		}, {
+			filename: "testdata/encrypted-key.yml",
+			url:      server.URL,
+			output: []string{
+				`client certificate "CN=Client,O=Prometheus,C=US": valid from`,
+				`SUCCESS: ` + server.URL + ` answered with status "200 OK"`,
+			},
+		}, {
+			filename: "testdata/no-client-cert.yml",
+			url:      server.URL,
+			errMsg:   "request to " + server.URL + " failed",
+		}, {
+			filename: "testdata/key-mismatch.yml",
+			errMsg:   "private key does not match public key",
+		}, {
+			filename: "testdata/invalid.yml",
+			errMsg:   "unable to load testdata/invalid.yml: at most one of ca & ca_file must be configured",
+		}, {
+			filename: "testdata/missing.yml",
+			errMsg:   "unable to load testdata/missing.yml",
+		},
+	}
+
+	for i, tc := range testCases {
+		o := checkOptions{
+			url:           tc.url,
+			timeout:       5 * time.Second,
+			expiryWarning: tc.expiryWarning,
+			now:           time.Now,
+		}
+		if !tc.now.IsZero() {
+			o.now = func() time.Time { return tc.now }
+		}
+
+		var out bytes.Buffer
+		err := check(&out, tc.filename, o)
+		if tc.errMsg == "" && err != nil {
+			t.Errorf("%d: unexpected error: %v", i, err)
+		}
+		if tc.errMsg != "" && (err == nil || !strings.Contains(err.Error(), tc.errMsg)) {
+			t.Errorf("%d: expected error containing %q, got %v", i, tc.errMsg, err)
+		}
+		for _, expected := range tc.output {
+			if !strings.Contains(out.String(), expected) {
+				t.Errorf("%d: expected output containing %q, got:\n%s", i, expected, out.String())
+			}
+		}
+	}
+}
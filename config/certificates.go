@@ -0,0 +1,248 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// certificateWarningInterval is the minimum time between two warnings about
+// the expiry of the same certificate.
+const certificateWarningInterval = 24 * time.Hour
+
+// certificateSource identifies where certificates are loaded from.
+type certificateSource struct {
+	// client is the name of the client which loaded the certificates.
+	client string
+	// usage is "ca" or "client".
+	usage string
+	// source describes where the certificates come from, such as their
+	// file or "<inline>".
+	source string
+}
+
+// loadedCertificate is the validity period of a loaded certificate.
+type loadedCertificate struct {
+	subject   string
+	serial    string
+	notBefore time.Time
+	notAfter  time.Time
+}
+
+// parseCertificates returns the validity periods of the certificates of the
+// PEM data. Duplicate certificates are returned once.
+func parseCertificates(b []byte) []loadedCertificate {
+	var (
+		loaded []loadedCertificate
+		seen   = map[string]struct{}{}
+	)
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		key := cert.Subject.String() + "\x00" + cert.SerialNumber.String()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		loaded = append(loaded, loadedCertificate{
+			subject:   cert.Subject.String(),
+			serial:    cert.SerialNumber.String(),
+			notBefore: cert.NotBefore,
+			notAfter:  cert.NotAfter,
+		})
+	}
+	return loaded
+}
+
+// CertificateCollector is a prometheus.Collector exporting the validity
+// periods of the CA and client certificates in use by the round trippers
+// created with WithCertificateCollector, including those reloaded from
+// changed files. It also logs warnings ahead of their expiry.
+type CertificateCollector struct {
+	logger     log.Logger
+	warnBefore time.Duration
+	now        func() time.Time
+	notBefore  *prometheus.Desc
+	notAfter   *prometheus.Desc
+
+	certsMtx sync.Mutex
+	certs    map[certificateSource][]loadedCertificate
+
+	mtx    sync.Mutex
+	warned map[string]time.Time
+}
+
+// NewCertificateCollector returns a collector of the loaded certificates.
+// The certificates expiring within warnBefore are logged to logger by
+// WarnExpiring, at most once a day each.
+func NewCertificateCollector(logger log.Logger, warnBefore time.Duration) *CertificateCollector {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	labels := []string{"client", "usage", "source", "subject", "serial"}
+	return &CertificateCollector{
+		logger:     logger,
+		warnBefore: warnBefore,
+		now:        time.Now,
+		notBefore: prometheus.NewDesc(
+			"http_client_tls_certificate_not_before_timestamp_seconds",
+			"Time from which the loaded TLS certificates are valid, by client, usage and source.",
+			labels, nil,
+		),
+		notAfter: prometheus.NewDesc(
+			"http_client_tls_certificate_not_after_timestamp_seconds",
+			"Time at which the loaded TLS certificates expire, by client, usage and source.",
+			labels, nil,
+		),
+		certs:  map[certificateSource][]loadedCertificate{},
+		warned: map[string]time.Time{},
+	}
+}
+
+// recorder returns a function recording the certificates of the PEM data of
+// the CA and of the client cert chain in use by the named client, in place of
+// those it used before. It returns nil if c is nil.
+func (c *CertificateCollector) recorder(client string, cfg *TLSConfig) func(ca, cert []byte) {
+	if c == nil {
+		return nil
+	}
+	return func(ca, cert []byte) {
+		loaded := map[certificateSource][]loadedCertificate{}
+		if certs := parseCertificates(ca); len(certs) > 0 {
+			loaded[certificateSource{client: client, usage: "ca", source: cfg.caSource()}] = certs
+		}
+		if certs := parseCertificates(cert); len(certs) > 0 {
+			loaded[certificateSource{client: client, usage: "client", source: source(len(cfg.Cert) > 0, cfg.CertFile)}] = certs
+		}
+
+		c.certsMtx.Lock()
+		defer c.certsMtx.Unlock()
+		c.removeClient(client)
+		for s, certs := range loaded {
+			c.certs[s] = certs
+		}
+	}
+}
+
+// RemoveClient forgets the certificates of the named client, such as a client
+// which isn't recreated after a configuration reload. The certificates of a
+// client are otherwise replaced by those of the next round tripper created
+// with the same name.
+func (c *CertificateCollector) RemoveClient(client string) {
+	c.certsMtx.Lock()
+	defer c.certsMtx.Unlock()
+	c.removeClient(client)
+}
+
+func (c *CertificateCollector) removeClient(client string) {
+	for s := range c.certs {
+		if s.client == client {
+			delete(c.certs, s)
+		}
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *CertificateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.notBefore
+	ch <- c.notAfter
+}
+
+// Collect implements the prometheus.Collector interface. It calls
+// WarnExpiring.
+func (c *CertificateCollector) Collect(ch chan<- prometheus.Metric) {
+	c.WarnExpiring()
+	for _, s := range c.sortedSources() {
+		for _, cert := range c.certificatesFrom(s) {
+			ch <- prometheus.MustNewConstMetric(c.notBefore, prometheus.GaugeValue,
+				float64(cert.notBefore.Unix()), s.client, s.usage, s.source, cert.subject, cert.serial)
+			ch <- prometheus.MustNewConstMetric(c.notAfter, prometheus.GaugeValue,
+				float64(cert.notAfter.Unix()), s.client, s.usage, s.source, cert.subject, cert.serial)
+		}
+	}
+}
+
+// WarnExpiring logs a warning for every loaded certificate which has
+// expired or expires within the warning duration of the collector, unless it
+// was already logged within the last day. It is called on every collection
+// and can be called periodically when the metrics aren't scraped.
+func (c *CertificateCollector) WarnExpiring() {
+	now := c.now()
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, s := range c.sortedSources() {
+		for _, cert := range c.certificatesFrom(s) {
+			if cert.notAfter.Sub(now) >= c.warnBefore {
+				continue
+			}
+			key := s.client + "\x00" + s.usage + "\x00" + s.source + "\x00" + cert.subject + "\x00" + cert.serial
+			if last, ok := c.warned[key]; ok && now.Sub(last) < certificateWarningInterval {
+				continue
+			}
+			c.warned[key] = now
+			msg := "TLS certificate expires soon"
+			if !now.Before(cert.notAfter) {
+				msg = "TLS certificate has expired"
+			}
+			level.Warn(c.logger).Log("msg", msg, "client", s.client, "usage", s.usage, "source", s.source,
+				"subject", cert.subject, "serial", cert.serial, "not_after", cert.notAfter.UTC().Format(time.RFC3339))
+		}
+	}
+}
+
+// sortedSources returns the sources of the loaded certificates, sorted by
+// client, usage and source.
+func (c *CertificateCollector) sortedSources() []certificateSource {
+	c.certsMtx.Lock()
+	defer c.certsMtx.Unlock()
+	sources := make([]certificateSource, 0, len(c.certs))
+	for s := range c.certs {
+		sources = append(sources, s)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].client != sources[j].client {
+			return sources[i].client < sources[j].client
+		}
+		if sources[i].usage != sources[j].usage {
+			return sources[i].usage < sources[j].usage
+		}
+		return sources[i].source < sources[j].source
+	})
+	return sources
+}
+
+// certificatesFrom returns the certificates last loaded from the source.
+func (c *CertificateCollector) certificatesFrom(s certificateSource) []loadedCertificate {
+	c.certsMtx.Lock()
+	defer c.certsMtx.Unlock()
+	return c.certs[s]
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCertificateCollector(t *testing.T) {
+	c := NewCertificateCollector(nil, 0)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	cfg := TLSConfig{
+		CAFile:   TLSCAChainPath,
+		CertFile: ClientCertificatePath,
+		KeyFile:  ClientKeyNoPassPath,
+	}
+	// Only the round trippers created with the collector are exported.
+	if _, err := NewTLSConfig(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRoundTripperFromConfig(HTTPClientConfig{TLSConfig: cfg}, "other", false); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(gatherValues(t, reg)); n != 0 {
+		t.Fatalf("Expected no certificate, got %d metrics", n)
+	}
+
+	if _, err := NewRoundTripperFromConfigWithOptions(HTTPClientConfig{TLSConfig: cfg}, "test", WithCertificateCollector(c)); err != nil {
+		t.Fatal(err)
+	}
+	values := gatherValues(t, reg)
+	if len(values) != 6 {
+		t.Errorf("Expected the validity of 3 certificates, got %v", values)
+	}
+
+	pair, err := tls.LoadX509KeyPair(ClientCertificatePath, ClientKeyNoPassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := ",client=test,serial=" + cert.SerialNumber.String() + ",source=" + ClientCertificatePath + ",subject=" + cert.Subject.String() + ",usage=client"
+	for name, expected := range map[string]time.Time{
+		"http_client_tls_certificate_not_before_timestamp_seconds": cert.NotBefore,
+		"http_client_tls_certificate_not_after_timestamp_seconds":  cert.NotAfter,
+	} {
+		if got := values[name+labels]; got != float64(expected.Unix()) {
+			t.Errorf("Expected %s%s to be %d, got %v", name, labels, expected.Unix(), got)
+		}
+	}
+}
+
+func TestCertificateCollectorReload(t *testing.T) {
+	bs := getCertificateBlobs(t)
+	tmpDir, err := ioutil.TempDir("", "certificates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	caFile := filepath.Join(tmpDir, "ca")
+	writeCertificate(bs, TLSCAChainPath, caFile)
+
+	c := NewCertificateCollector(nil, 0)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+	rt, err := NewRoundTripperFromConfigWithOptions(HTTPClientConfig{TLSConfig: TLSConfig{CAFile: caFile}}, "test", WithCertificateCollector(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(gatherValues(t, reg)); n != 4 {
+		t.Fatalf("Expected the validity of 2 CA certificates, got %d metrics", n)
+	}
+
+	writeCertificate(bs, ServerCertificatePath, caFile)
+	rt.(*tlsRoundTripper).reload()
+	values := gatherValues(t, reg)
+	if len(values) != 2 {
+		t.Fatalf("Expected the validity of the reloaded CA certificate, got %v", values)
+	}
+	for k := range values {
+		if !strings.Contains(k, "subject=CN=prometheus.example.com") {
+			t.Errorf("Unexpected certificate %s", k)
+		}
+	}
+
+	// The inline certificates of different clients are kept apart.
+	ca := string(bs[ServerCertificatePath])
+	for _, name := range []string{"test", "other"} {
+		if _, err := NewRoundTripperFromConfigWithOptions(HTTPClientConfig{TLSConfig: TLSConfig{CA: ca}}, name, WithCertificateCollector(c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	values = gatherValues(t, reg)
+	if len(values) != 4 {
+		t.Fatalf("Expected the validity of the inline CA certificate of 2 clients, got %v", values)
+	}
+	for k := range values {
+		if strings.Contains(k, "source="+caFile) {
+			t.Errorf("Expected the certificates of the replaced round tripper to be removed, got %s", k)
+		}
+	}
+
+	// The round trippers without certificates replace those with.
+	if _, err := NewRoundTripperFromConfigWithOptions(HTTPClientConfig{}, "test", WithCertificateCollector(c)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(gatherValues(t, reg)); n != 2 {
+		t.Fatalf("Expected the validity of 1 CA certificate, got %d metrics", n)
+	}
+	c.RemoveClient("other")
+	if n := len(gatherValues(t, reg)); n != 0 {
+		t.Fatalf("Expected no certificate, got %d metrics", n)
+	}
+}
+
+func TestCertificateExpiryWarnings(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewCertificateCollector(log.NewLogfmtLogger(&buf), 30*24*time.Hour)
+	if _, err := NewRoundTripperFromConfigWithOptions(HTTPClientConfig{TLSConfig: TLSConfig{CAFile: TLSCAChainPath}}, "test", WithCertificateCollector(c)); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	countWarnings := func(msg string) int {
+		n := strings.Count(buf.String(), msg)
+		buf.Reset()
+		return n
+	}
+
+	c.WarnExpiring()
+	if n := countWarnings("level=warn"); n != 0 {
+		t.Errorf("Expected no warning, got %d", n)
+	}
+
+	now = time.Date(2066, 10, 1, 0, 0, 0, 0, time.UTC)
+	c.WarnExpiring()
+	if n := countWarnings(`msg="TLS certificate expires soon"`); n != 2 {
+		t.Errorf("Expected 2 warnings, got %d", n)
+	}
+	c.WarnExpiring()
+	if n := countWarnings("level=warn"); n != 0 {
+		t.Errorf("Expected the warnings not to be repeated within a day, got %d", n)
+	}
+
+	now = time.Date(2067, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.WarnExpiring()
+	if n := countWarnings(`msg="TLS certificate has expired"`); n != 2 {
+		t.Errorf("Expected 2 warnings, got %d", n)
+	}
+}
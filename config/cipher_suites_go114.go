@@ -0,0 +1,34 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.14
+
+package config
+
+import "crypto/tls"
+
+// cipherSuites maps the names of the cipher suites implemented by crypto/tls,
+// including the insecure ones, to their values.
+var cipherSuites = func() map[string]TLSCipherSuite {
+	m := map[string]TLSCipherSuite{}
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		m[cs.Name] = TLSCipherSuite(cs.ID)
+	}
+	return m
+}()
+
+// cipherSuiteName returns the standard name of the cipher suite, or its hex
+// value if it is unknown.
+func cipherSuiteName(id uint16) string {
+	return tls.CipherSuiteName(id)
+}
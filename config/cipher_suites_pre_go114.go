@@ -0,0 +1,77 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !go1.14
+
+package config
+
+import "fmt"
+
+// knownCipherSuites lists the cipher suites implemented by crypto/tls,
+// including the insecure ones, as tls.CipherSuites and
+// tls.InsecureCipherSuites are only available from Go 1.14. The TLS 1.3
+// cipher suites are listed by value as their constants are only available
+// from Go 1.12. The standard names come first, followed by the names used
+// by Go 1.14 and 1.15.
+var knownCipherSuites = []struct {
+	name string
+	id   uint16
+}{
+	{"TLS_AES_128_GCM_SHA256", 0x1301},
+	{"TLS_AES_256_GCM_SHA384", 0x1302},
+	{"TLS_CHACHA20_POLY1305_SHA256", 0x1303},
+	{"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA", 0xc009},
+	{"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA", 0xc00a},
+	{"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA", 0xc013},
+	{"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA", 0xc014},
+	{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", 0xc02b},
+	{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", 0xc02c},
+	{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", 0xc02f},
+	{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", 0xc030},
+	{"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256", 0xcca8},
+	{"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256", 0xcca9},
+	{"TLS_RSA_WITH_RC4_128_SHA", 0x0005},
+	{"TLS_RSA_WITH_3DES_EDE_CBC_SHA", 0x000a},
+	{"TLS_RSA_WITH_AES_128_CBC_SHA", 0x002f},
+	{"TLS_RSA_WITH_AES_256_CBC_SHA", 0x0035},
+	{"TLS_RSA_WITH_AES_128_CBC_SHA256", 0x003c},
+	{"TLS_RSA_WITH_AES_128_GCM_SHA256", 0x009c},
+	{"TLS_RSA_WITH_AES_256_GCM_SHA384", 0x009d},
+	{"TLS_ECDHE_ECDSA_WITH_RC4_128_SHA", 0xc007},
+	{"TLS_ECDHE_RSA_WITH_RC4_128_SHA", 0xc011},
+	{"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA", 0xc012},
+	{"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256", 0xc023},
+	{"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256", 0xc027},
+	{"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305", 0xcca8},
+	{"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305", 0xcca9},
+}
+
+// cipherSuites maps the names of the known cipher suites to their values.
+var cipherSuites = func() map[string]TLSCipherSuite {
+	m := map[string]TLSCipherSuite{}
+	for _, cs := range knownCipherSuites {
+		m[cs.name] = TLSCipherSuite(cs.id)
+	}
+	return m
+}()
+
+// cipherSuiteName returns the standard name of the cipher suite, or its hex
+// value if it is unknown.
+func cipherSuiteName(id uint16) string {
+	for _, cs := range knownCipherSuites {
+		if cs.id == id {
+			return cs.name
+		}
+	}
+	return fmt.Sprintf("0x%04X", id)
+}
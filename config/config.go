@@ -16,19 +16,186 @@
 
 package config
 
-// Secret special type for storing secrets.
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/prometheus/common/model"
+)
+
+const secretToken = "<secret>"
+
+// Secret special type for storing secrets. See SecretValue for the secrets
+// which may reference a SecretProvider.
 type Secret string
 
-// MarshalYAML implements the yaml.Marshaler interface for Secrets.
+// MarshalYAML implements the yaml.Marshaler interface for Secrets. Secrets
+// are redacted unless marshalled with MarshalYAMLWithSecrets.
 func (s Secret) MarshalYAML() (interface{}, error) {
+	if strings.HasPrefix(string(s), secretRevealPrefix) {
+		return strings.TrimPrefix(string(s), secretRevealPrefix), nil
+	}
 	if s != "" {
-		return "<secret>", nil
+		return secretToken, nil
 	}
 	return nil, nil
 }
 
-//UnmarshalYAML implements the yaml.Unmarshaler interface for Secrets.
+// MarshalJSON implements the json.Marshaler interface for Secrets, like
+// MarshalYAML. Secrets are redacted unless marshalled with
+// MarshalJSONWithSecrets.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	if strings.HasPrefix(string(s), secretRevealPrefix) {
+		return json.Marshal(strings.TrimPrefix(string(s), secretRevealPrefix))
+	}
+	if s != "" {
+		return json.Marshal(secretToken)
+	}
+	return json.Marshal("")
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for Secrets. Secret
+// references are rejected, as they would be used as the secret by the callers
+// converting the Secret to a string.
 func (s *Secret) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var ref map[string]string
+	if err := unmarshal(&ref); err == nil {
+		return fmt.Errorf("secret references are not supported for this secret")
+	}
+
 	type plain Secret
-	return unmarshal((*plain)(s))
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if strings.HasPrefix(string(*s), secretRevealPrefix) {
+		return fmt.Errorf("invalid secret")
+	}
+	return nil
+}
+
+// JoinDir joins dir and path if path is relative. Empty and absolute paths
+// are returned unchanged.
+func JoinDir(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for Secrets, like
+// UnmarshalYAML.
+func (s *Secret) UnmarshalJSON(b []byte) error {
+	if isJSONNull(b) {
+		return nil
+	}
+	return s.UnmarshalYAML(jsonUnmarshal(b))
+}
+
+// jsonUnmarshal adapts JSON data to the unmarshal function of the
+// yaml.Unmarshaler interface, so that the UnmarshalYAML methods validating
+// the configurations can be reused to implement the json.Unmarshaler
+// interface.
+func jsonUnmarshal(b []byte) func(interface{}) error {
+	return func(v interface{}) error {
+		return json.Unmarshal(b, v)
+	}
+}
+
+// jsonMarshal marshals the value returned by the MarshalYAML method of m to
+// JSON.
+func jsonMarshal(m yaml.Marshaler) ([]byte, error) {
+	v, err := m.MarshalYAML()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// jsonMarshalWithDurations marshals v to JSON, except that the durations
+// found under the given keys are encoded as in YAML, such as "1m30s", rather
+// than as integer nanoseconds.
+func jsonMarshalWithDurations(v interface{}, durations map[string]model.Duration) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for k, d := range durations {
+		// The empty durations may be omitted.
+		if _, ok := m[k]; !ok {
+			continue
+		}
+		if m[k], err = json.Marshal(d.String()); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(m)
+}
+
+// jsonUnmarshalWithDurations is like jsonUnmarshal, except that the durations
+// found under the given keys are parsed as in YAML, such as "1m30s", and
+// stored in the matching pointers. Integer nanoseconds are accepted too, and
+// null leaves a duration unchanged.
+func jsonUnmarshalWithDurations(b []byte, durations map[string]*model.Duration) func(interface{}) error {
+	return func(v interface{}) error {
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(b, &m); err != nil || m == nil {
+			return json.Unmarshal(b, v)
+		}
+		parsed := map[*model.Duration]model.Duration{}
+		for k, d := range durations {
+			raw, ok := m[k]
+			if !ok {
+				continue
+			}
+			delete(m, k)
+			if isJSONNull(raw) {
+				continue
+			}
+			dur, err := parseJSONDuration(raw)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %s", k, err)
+			}
+			parsed[d] = dur
+		}
+		rest, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(rest, v); err != nil {
+			return err
+		}
+		for d, dur := range parsed {
+			*d = dur
+		}
+		return nil
+	}
+}
+
+// parseJSONDuration parses a JSON string holding a duration, or a JSON number
+// of nanoseconds.
+func parseJSONDuration(b []byte) (model.Duration, error) {
+	var ns int64
+	if err := json.Unmarshal(b, &ns); err == nil {
+		return model.Duration(ns), nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return 0, err
+	}
+	return model.ParseDuration(s)
+}
+
+// isJSONNull returns true if b is the JSON null literal, which the
+// json.Unmarshaler implementations treat as a no-op.
+func isJSONNull(b []byte) bool {
+	return string(bytes.TrimSpace(b)) == "null"
 }
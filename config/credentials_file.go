@@ -0,0 +1,133 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// credentialsFileOptions configures the caching of the credentials files.
+type credentialsFileOptions struct {
+	// refreshInterval is the minimum time between two checks of the
+	// modification time of a file. Files are checked for every read if zero.
+	refreshInterval time.Duration
+	// onError, if not nil, is called with the errors reading a file.
+	onError func(error)
+}
+
+// credentialsFile caches the content of a file holding credentials, such as
+// a bearer token file. The file is read again when its modification time or
+// size changes. If it can't be read, the last content read successfully is
+// used.
+type credentialsFile struct {
+	filename string
+	// description names the file in the errors, such as "bearer token file".
+	description string
+	opts        credentialsFileOptions
+	now         func() time.Time
+
+	mtx     sync.Mutex
+	content []byte
+	loaded  bool
+	// err is the error of the last check of the file.
+	err     error
+	checked time.Time
+	modTime time.Time
+	size    int64
+}
+
+// newCredentialsFile returns the cache of the given file.
+func (o credentialsFileOptions) newCredentialsFile(filename, description string) *credentialsFile {
+	return &credentialsFile{
+		filename:    filename,
+		description: description,
+		opts:        o,
+		now:         time.Now,
+	}
+}
+
+// read returns the content of the file, without the leading and trailing
+// white space. It fails only if the file has never been read successfully.
+func (f *credentialsFile) read() (string, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.refresh() && f.err != nil {
+		err := fmt.Errorf("unable to read %s %s: %s", f.description, f.filename, f.err)
+		if f.opts.onError != nil {
+			f.opts.onError(err)
+		}
+		if !f.loaded {
+			return "", err
+		}
+	}
+	return strings.TrimSpace(string(f.content)), nil
+}
+
+// readBytes returns the content of the file as it is, or the error of the
+// last check of the file. Unlike read, it doesn't fall back to the last
+// content read successfully and doesn't report the errors.
+func (f *credentialsFile) readBytes() ([]byte, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.refresh()
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.content, nil
+}
+
+// refresh checks the file unless it has been read successfully less than the
+// refresh interval ago, and returns true if it did. f.mtx must be held.
+func (f *credentialsFile) refresh() bool {
+	now := f.now()
+	if f.loaded && now.Sub(f.checked) < f.opts.refreshInterval {
+		return false
+	}
+	f.checked = now
+	f.err = f.load()
+	return true
+}
+
+// load reads the file if its modification time or size has changed since it
+// was last read.
+func (f *credentialsFile) load() error {
+	file, err := os.Open(f.filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if f.loaded && fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return nil
+	}
+	b, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	f.content = b
+	f.loaded = true
+	f.modTime = fi.ModTime()
+	f.size = fi.Size()
+	return nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCredentialsFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	filename := filepath.Join(tmpDir, "token")
+	modTime := time.Now().Add(-time.Hour)
+	write := func(content string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		// Every write gets a distinct modification time, whatever the
+		// resolution of the file system.
+		modTime = modTime.Add(time.Second)
+		if err := os.Chtimes(filename, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		errs []error
+		now  = time.Now()
+	)
+	f := credentialsFileOptions{
+		refreshInterval: time.Minute,
+		onError:         func(err error) { errs = append(errs, err) },
+	}.newCredentialsFile(filename, "bearer token file")
+	f.now = func() time.Time { return now }
+
+	check := func(expected string) {
+		t.Helper()
+		got, err := f.read()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	}
+
+	// The file can't be read before it exists.
+	if _, err := f.read(); err == nil || !strings.Contains(err.Error(), "unable to read bearer token file "+filename) {
+		t.Errorf("Expected an error reading the missing file, got %v", err)
+	}
+
+	write("token1\n")
+	check("token1")
+
+	// The file isn't checked again before the refresh interval.
+	write("token2")
+	now = now.Add(30 * time.Second)
+	check("token1")
+	now = now.Add(time.Minute)
+	check("token2")
+
+	// The last good value is used if the file can't be read.
+	if err := os.Remove(filename); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	check("token2")
+
+	write("token3")
+	now = now.Add(time.Minute)
+	check("token3")
+
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, got %v", errs)
+	}
+	for _, err := range errs {
+		if !strings.Contains(err.Error(), "no such file or directory") {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestCredentialsFileReadBytes(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	filename := filepath.Join(tmpDir, "ca.pem")
+	if err := ioutil.WriteFile(filename, []byte("cert\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	f := credentialsFileOptions{refreshInterval: time.Minute}.newCredentialsFile(filename, "CA cert file")
+	f.now = func() time.Time { return now }
+
+	// The content is returned as it is.
+	if b, err := f.readBytes(); err != nil || string(b) != "cert\n" {
+		t.Fatalf("Expected %q, got %q, %v", "cert\n", b, err)
+	}
+
+	// Unlike read, the error is returned, and kept until the next check.
+	if err := os.Remove(filename); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		if _, err := f.readBytes(); err == nil || !os.IsNotExist(err) {
+			t.Errorf("Expected an error reading the missing file, got %v", err)
+		}
+	}
+}
+
+func TestCredentialsFileErrorHook(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	bearerFile := filepath.Join(tmpDir, "bearer.token")
+	if err := ioutil.WriteFile(bearerFile, []byte(BearerToken), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != ExpectedBearer {
+			t.Errorf("Expected Authorization header %q, got %q", ExpectedBearer, auth)
+		}
+	}))
+	defer testServer.Close()
+
+	var errs []error
+	client, err := NewClientFromConfigWithOptions(HTTPClientConfig{BearerTokenFile: bearerFile}, "test",
+		WithCredentialsFileErrorHook(func(err error) { errs = append(errs, err) }),
+	)
+	if err != nil {
+		t.Fatalf("Error creating HTTP Client: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(testServer.URL)
+		if err != nil {
+			t.Fatalf("Can't connect to the test server: %v", err)
+		}
+		resp.Body.Close()
+
+		// The requests keep using the token once its file is gone.
+		if err := os.Remove(bearerFile); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "unable to read bearer token file "+bearerFile) {
+		t.Errorf("Expected an error reading the bearer token file, got %v", errs)
+	}
+}
@@ -17,20 +17,33 @@ package config
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/mwitkow/go-conntrack"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http/httpproxy"
 	"golang.org/x/net/http2"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 	"gopkg.in/yaml.v2"
+
+	"github.com/prometheus/common/model"
 )
 
 type closeIdler interface {
@@ -39,9 +52,57 @@ type closeIdler interface {
 
 // BasicAuth contains basic HTTP authentication credentials.
 type BasicAuth struct {
-	Username     string `yaml:"username"`
-	Password     Secret `yaml:"password,omitempty"`
-	PasswordFile string `yaml:"password_file,omitempty"`
+	Username     string      `yaml:"username" json:"username"`
+	Password     SecretValue `yaml:"password,omitempty" json:"password,omitempty"`
+	PasswordFile string      `yaml:"password_file,omitempty" json:"password_file,omitempty"`
+}
+
+// Authorization contains HTTP authorization credentials.
+type Authorization struct {
+	// The authorization scheme, "Bearer" if empty.
+	Type            string      `yaml:"type,omitempty" json:"type,omitempty"`
+	Credentials     SecretValue `yaml:"credentials,omitempty" json:"credentials,omitempty"`
+	CredentialsFile string      `yaml:"credentials_file,omitempty" json:"credentials_file,omitempty"`
+}
+
+// OAuth2 contains the OAuth2 client credentials flow configuration.
+type OAuth2 struct {
+	ClientID         string            `yaml:"client_id" json:"client_id"`
+	ClientSecret     SecretValue       `yaml:"client_secret,omitempty" json:"client_secret,omitempty"`
+	ClientSecretFile string            `yaml:"client_secret_file,omitempty" json:"client_secret_file,omitempty"`
+	Scopes           []string          `yaml:"scopes,omitempty" json:"scopes,omitempty"`
+	TokenURL         string            `yaml:"token_url" json:"token_url"`
+	EndpointParams   map[string]string `yaml:"endpoint_params,omitempty" json:"endpoint_params,omitempty"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (a Authorization) MarshalJSON() ([]byte, error) {
+	type plain Authorization
+	return json.Marshal(struct {
+		plain
+		Credentials *SecretValue `json:"credentials,omitempty"`
+	}{plain(a), omitEmptySecret(a.Credentials)})
+}
+
+// SetDirectory joins any relative file paths with dir.
+func (a *Authorization) SetDirectory(dir string) {
+	a.Credentials.SetDirectory(dir)
+	a.CredentialsFile = JoinDir(dir, a.CredentialsFile)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (o OAuth2) MarshalJSON() ([]byte, error) {
+	type plain OAuth2
+	return json.Marshal(struct {
+		plain
+		ClientSecret *SecretValue `json:"client_secret,omitempty"`
+	}{plain(o), omitEmptySecret(o.ClientSecret)})
+}
+
+// SetDirectory joins any relative file paths with dir.
+func (o *OAuth2) SetDirectory(dir string) {
+	o.ClientSecret.SetDirectory(dir)
+	o.ClientSecretFile = JoinDir(dir, o.ClientSecretFile)
 }
 
 // URL is a custom URL type that allows validation at configuration load time.
@@ -72,32 +133,203 @@ func (u URL) MarshalYAML() (interface{}, error) {
 	return nil, nil
 }
 
+// UnmarshalJSON implements the json.Unmarshaler interface for URLs.
+func (u *URL) UnmarshalJSON(b []byte) error {
+	if isJSONNull(b) {
+		return nil
+	}
+	return u.UnmarshalYAML(jsonUnmarshal(b))
+}
+
+// MarshalJSON implements the json.Marshaler interface for URLs.
+func (u URL) MarshalJSON() ([]byte, error) {
+	return jsonMarshal(u)
+}
+
 // HTTPClientConfig configures an HTTP client.
 type HTTPClientConfig struct {
 	// The HTTP basic authentication credentials for the targets.
-	BasicAuth *BasicAuth `yaml:"basic_auth,omitempty"`
+	BasicAuth *BasicAuth `yaml:"basic_auth,omitempty" json:"basic_auth,omitempty"`
+	// The HTTP authorization credentials for the targets.
+	Authorization *Authorization `yaml:"authorization,omitempty" json:"authorization,omitempty"`
+	// The OAuth2 client credentials used to fetch a token for the targets.
+	OAuth2 *OAuth2 `yaml:"oauth2,omitempty" json:"oauth2,omitempty"`
+	// The AWS Signature Version 4 signing settings for the targets.
+	SigV4 *SigV4 `yaml:"sigv4,omitempty" json:"sigv4,omitempty"`
 	// The bearer token for the targets.
-	BearerToken Secret `yaml:"bearer_token,omitempty"`
+	BearerToken SecretValue `yaml:"bearer_token,omitempty" json:"bearer_token,omitempty"`
 	// The bearer token file for the targets.
-	BearerTokenFile string `yaml:"bearer_token_file,omitempty"`
+	BearerTokenFile string `yaml:"bearer_token_file,omitempty" json:"bearer_token_file,omitempty"`
 	// HTTP proxy server to use to connect to the targets.
-	ProxyURL URL `yaml:"proxy_url,omitempty"`
+	ProxyURL URL `yaml:"proxy_url,omitempty" json:"proxy_url,omitempty"`
+	// Comma-separated list of hosts, domains, IP addresses and CIDR ranges
+	// which must not be reached through ProxyURL, with the syntax of the
+	// NO_PROXY environment variable.
+	NoProxy string `yaml:"no_proxy,omitempty" json:"no_proxy,omitempty"`
+	// Use the proxy defined by the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	// environment variables.
+	ProxyFromEnvironment bool `yaml:"proxy_from_environment,omitempty" json:"proxy_from_environment,omitempty"`
+	// Headers to send to the proxy in CONNECT requests.
+	ProxyConnectHeader map[string][]SecretValue `yaml:"proxy_connect_header,omitempty" json:"proxy_connect_header,omitempty"`
 	// TLSConfig to use to connect to the targets.
-	TLSConfig TLSConfig `yaml:"tls_config,omitempty"`
+	TLSConfig TLSConfig `yaml:"tls_config,omitempty" json:"tls_config,omitempty"`
+	// Additional headers to send to the targets.
+	HTTPHeaders map[string]Header `yaml:"http_headers,omitempty" json:"http_headers,omitempty"`
+	// The retries of the failed requests. Disabled if nil.
+	Retry *RetryConfig `yaml:"retry,omitempty" json:"retry,omitempty"`
+	// The minimum time between two checks of the modification time of the
+	// credentials files, such as bearer_token_file, of the TLS files and of
+	// the files of the CA directory. The files are checked for every request
+	// if zero.
+	CredentialsFileRefreshInterval model.Duration `yaml:"credentials_file_refresh_interval,omitempty" json:"credentials_file_refresh_interval,omitempty"`
+	// The rate limit of the requests. Disabled if nil.
+	RateLimit *RateLimitConfig `yaml:"rate_limit,omitempty" json:"rate_limit,omitempty"`
+	// The maximum number of requests in flight. Unlimited if zero.
+	MaxConcurrentRequests int `yaml:"max_concurrent_requests,omitempty" json:"max_concurrent_requests,omitempty"`
+	// FollowRedirects specifies whether the client should follow HTTP 3xx
+	// redirects. Redirects are followed if nil.
+	FollowRedirects *bool `yaml:"follow_redirects,omitempty" json:"follow_redirects,omitempty"`
+	// EnableHTTP2 specifies whether the client should negotiate HTTP/2.
+	// HTTP/2 is enabled if nil.
+	EnableHTTP2 *bool `yaml:"enable_http2,omitempty" json:"enable_http2,omitempty"`
+	// The maximum number of idle connections, 20000 if zero.
+	MaxIdleConns int `yaml:"max_idle_conns,omitempty" json:"max_idle_conns,omitempty"`
+	// The maximum number of idle connections per host, 1000 if zero.
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host,omitempty" json:"max_idle_conns_per_host,omitempty"`
+	// How long idle connections are kept, 5m if zero.
+	IdleConnTimeout model.Duration `yaml:"idle_conn_timeout,omitempty" json:"idle_conn_timeout,omitempty"`
+	// The timeout for establishing connections, unlimited if zero.
+	DialTimeout model.Duration `yaml:"dial_timeout,omitempty" json:"dial_timeout,omitempty"`
+	// The timeout for TLS handshakes, 10s if zero.
+	TLSHandshakeTimeout model.Duration `yaml:"tls_handshake_timeout,omitempty" json:"tls_handshake_timeout,omitempty"`
+}
+
+// Header holds the values of an HTTP header. All the values are sent, in
+// the order of the fields.
+type Header struct {
+	Values  []string      `yaml:"values,omitempty" json:"values,omitempty"`
+	Secrets []SecretValue `yaml:"secrets,omitempty" json:"secrets,omitempty"`
+	Files   []string      `yaml:"files,omitempty" json:"files,omitempty"`
+}
+
+// SetDirectory joins any relative file paths with dir.
+func (h *Header) SetDirectory(dir string) {
+	for i := range h.Secrets {
+		h.Secrets[i].SetDirectory(dir)
+	}
+	for i := range h.Files {
+		h.Files[i] = JoinDir(dir, h.Files[i])
+	}
+}
+
+// reservedHeaders are the headers that can't be set through HTTPHeaders
+// because they are managed by the HTTP client or its other settings.
+var reservedHeaders = map[string]struct{}{
+	"Authorization":       {},
+	"Host":                {},
+	"Content-Encoding":    {},
+	"Content-Length":      {},
+	"Content-Type":        {},
+	"User-Agent":          {},
+	"Connection":          {},
+	"Keep-Alive":          {},
+	"Proxy-Authenticate":  {},
+	"Proxy-Authorization": {},
+	"Www-Authenticate":    {},
+	"Accept-Encoding":     {},
+	"Transfer-Encoding":   {},
+	"Upgrade":             {},
+	"Te":                  {},
+	"Trailer":             {},
 }
 
 // Validate validates the HTTPClientConfig to check only one of BearerToken,
-// BasicAuth and BearerTokenFile is configured.
+// BasicAuth, Authorization, OAuth2, SigV4 and BearerTokenFile is configured.
 func (c *HTTPClientConfig) Validate() error {
-	if len(c.BearerToken) > 0 && len(c.BearerTokenFile) > 0 {
+	if !c.BearerToken.IsZero() && len(c.BearerTokenFile) > 0 {
 		return fmt.Errorf("at most one of bearer_token & bearer_token_file must be configured")
 	}
-	if c.BasicAuth != nil && (len(c.BearerToken) > 0 || len(c.BearerTokenFile) > 0) {
+	if c.BasicAuth != nil && (!c.BearerToken.IsZero() || len(c.BearerTokenFile) > 0) {
 		return fmt.Errorf("at most one of basic_auth, bearer_token & bearer_token_file must be configured")
 	}
-	if c.BasicAuth != nil && (string(c.BasicAuth.Password) != "" && c.BasicAuth.PasswordFile != "") {
+	if c.BasicAuth != nil && (!c.BasicAuth.Password.IsZero() && c.BasicAuth.PasswordFile != "") {
 		return fmt.Errorf("at most one of basic_auth password & password_file must be configured")
 	}
+	if c.OAuth2 != nil {
+		if c.BasicAuth != nil || !c.BearerToken.IsZero() || len(c.BearerTokenFile) > 0 {
+			return fmt.Errorf("at most one of basic_auth, oauth2, bearer_token & bearer_token_file must be configured")
+		}
+		if !c.OAuth2.ClientSecret.IsZero() && len(c.OAuth2.ClientSecretFile) > 0 {
+			return fmt.Errorf("at most one of oauth2 client_secret & client_secret_file must be configured")
+		}
+		if len(c.OAuth2.TokenURL) == 0 {
+			return fmt.Errorf("oauth2 token_url must be configured")
+		}
+	}
+	if c.Authorization != nil {
+		if !c.BearerToken.IsZero() || len(c.BearerTokenFile) > 0 {
+			return fmt.Errorf("authorization is not compatible with bearer_token & bearer_token_file")
+		}
+		if c.BasicAuth != nil || c.OAuth2 != nil {
+			return fmt.Errorf("at most one of basic_auth, oauth2 & authorization must be configured")
+		}
+		if !c.Authorization.Credentials.IsZero() && len(c.Authorization.CredentialsFile) > 0 {
+			return fmt.Errorf("at most one of authorization credentials & credentials_file must be configured")
+		}
+		if strings.ToLower(strings.TrimSpace(c.Authorization.Type)) == "basic" {
+			return fmt.Errorf(`authorization type cannot be set to "basic", use "basic_auth" instead`)
+		}
+	}
+	if c.SigV4 != nil {
+		if c.BasicAuth != nil || c.Authorization != nil || c.OAuth2 != nil || !c.BearerToken.IsZero() || len(c.BearerTokenFile) > 0 {
+			return fmt.Errorf("sigv4 is not compatible with basic_auth, authorization, oauth2, bearer_token & bearer_token_file")
+		}
+		if (len(c.SigV4.AccessKey) > 0) != !c.SigV4.SecretKey.IsZero() {
+			return fmt.Errorf("sigv4 access_key & secret_key must be configured together")
+		}
+		if len(c.SigV4.AccessKey) > 0 && len(c.SigV4.Profile) > 0 {
+			return fmt.Errorf("at most one of sigv4 access_key & profile must be configured")
+		}
+	}
+	if c.ProxyFromEnvironment && c.ProxyURL.URL != nil {
+		return fmt.Errorf("if proxy_from_environment is configured, proxy_url must not be configured")
+	}
+	if c.ProxyFromEnvironment && len(c.NoProxy) > 0 {
+		return fmt.Errorf("if proxy_from_environment is configured, no_proxy must not be configured")
+	}
+	if c.ProxyURL.URL == nil && len(c.NoProxy) > 0 {
+		return fmt.Errorf("if no_proxy is configured, proxy_url must also be configured")
+	}
+	if len(c.ProxyConnectHeader) > 0 && c.ProxyURL.URL == nil && !c.ProxyFromEnvironment {
+		return fmt.Errorf("if proxy_connect_header is configured, proxy_url or proxy_from_environment must also be configured")
+	}
+	if c.MaxIdleConns < 0 {
+		return fmt.Errorf("max_idle_conns must not be negative")
+	}
+	if c.MaxIdleConnsPerHost < 0 {
+		return fmt.Errorf("max_idle_conns_per_host must not be negative")
+	}
+	if c.RateLimit != nil {
+		if err := c.RateLimit.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.CredentialsFileRefreshInterval < 0 {
+		return fmt.Errorf("credentials_file_refresh_interval must not be negative")
+	}
+	if c.MaxConcurrentRequests < 0 {
+		return fmt.Errorf("max_concurrent_requests must not be negative")
+	}
+	if c.Retry != nil {
+		if err := c.Retry.Validate(); err != nil {
+			return err
+		}
+	}
+	for name := range c.HTTPHeaders {
+		if _, ok := reservedHeaders[http.CanonicalHeaderKey(name)]; ok {
+			return fmt.Errorf("setting header %q is not allowed", http.CanonicalHeaderKey(name))
+		}
+	}
 	return nil
 }
 
@@ -110,66 +342,385 @@ func (c *HTTPClientConfig) UnmarshalYAML(unmarshal func(interface{}) error) erro
 	return c.Validate()
 }
 
+// UnmarshalJSON implements the json.Unmarshaler interface. The durations are
+// parsed as in YAML.
+func (c *HTTPClientConfig) UnmarshalJSON(b []byte) error {
+	return c.UnmarshalYAML(jsonUnmarshalWithDurations(b, map[string]*model.Duration{
+		"credentials_file_refresh_interval": &c.CredentialsFileRefreshInterval,
+		"idle_conn_timeout":                 &c.IdleConnTimeout,
+		"dial_timeout":                      &c.DialTimeout,
+		"tls_handshake_timeout":             &c.TLSHandshakeTimeout,
+	}))
+}
+
+// MarshalJSON implements the json.Marshaler interface. The durations are
+// encoded as in YAML, such as "1m30s".
+func (c HTTPClientConfig) MarshalJSON() ([]byte, error) {
+	type plain HTTPClientConfig
+	v := struct {
+		plain
+		BearerToken *SecretValue `json:"bearer_token,omitempty"`
+	}{plain(c), omitEmptySecret(c.BearerToken)}
+	return jsonMarshalWithDurations(v, map[string]model.Duration{
+		"credentials_file_refresh_interval": c.CredentialsFileRefreshInterval,
+		"idle_conn_timeout":                 c.IdleConnTimeout,
+		"dial_timeout":                      c.DialTimeout,
+		"tls_handshake_timeout":             c.TLSHandshakeTimeout,
+	})
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (a BasicAuth) MarshalJSON() ([]byte, error) {
+	type plain BasicAuth
+	return json.Marshal(struct {
+		plain
+		Password *SecretValue `json:"password,omitempty"`
+	}{plain(a), omitEmptySecret(a.Password)})
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
 func (a *BasicAuth) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	type plain BasicAuth
 	return unmarshal((*plain)(a))
 }
 
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (a *BasicAuth) UnmarshalJSON(b []byte) error {
+	return a.UnmarshalYAML(jsonUnmarshal(b))
+}
+
+// SetDirectory joins any relative file paths with dir.
+func (a *BasicAuth) SetDirectory(dir string) {
+	a.Password.SetDirectory(dir)
+	a.PasswordFile = JoinDir(dir, a.PasswordFile)
+}
+
+// SetDirectory joins any relative file paths with dir.
+func (c *HTTPClientConfig) SetDirectory(dir string) {
+	if c.BasicAuth != nil {
+		c.BasicAuth.SetDirectory(dir)
+	}
+	if c.Authorization != nil {
+		c.Authorization.SetDirectory(dir)
+	}
+	if c.OAuth2 != nil {
+		c.OAuth2.SetDirectory(dir)
+	}
+	if c.SigV4 != nil {
+		c.SigV4.SecretKey.SetDirectory(dir)
+	}
+	c.BearerToken.SetDirectory(dir)
+	c.BearerTokenFile = JoinDir(dir, c.BearerTokenFile)
+	for _, values := range c.ProxyConnectHeader {
+		for i := range values {
+			values[i].SetDirectory(dir)
+		}
+	}
+	c.TLSConfig.SetDirectory(dir)
+	for name, h := range c.HTTPHeaders {
+		h.SetDirectory(dir)
+		c.HTTPHeaders[name] = h
+	}
+}
+
+// DialContextFunc defines the signature of the DialContext() function implemented by net.Dialer.
+type DialContextFunc func(context.Context, string, string) (net.Conn, error)
+
+// httpClientOptions holds the settings of an HTTP client that can't be
+// expressed in HTTPClientConfig.
+type httpClientOptions struct {
+	dialContextFunc      DialContextFunc
+	keepAlivesDisabled   bool
+	conntrackDisabled    bool
+	userAgent            string
+	middlewares          []func(http.RoundTripper) http.RoundTripper
+	registerer           prometheus.Registerer
+	certificateCollector *CertificateCollector
+	// credentialsFileErrorHook is called with the errors reading the
+	// credentials files.
+	credentialsFileErrorHook func(error)
+}
+
+// HTTPClientOption defines an option that can be applied to the HTTP client.
+type HTTPClientOption func(options *httpClientOptions)
+
+// WithDialContextFunc allows you to override the function used to establish
+// connections. The default is net.Dialer.DialContext.
+func WithDialContextFunc(fn DialContextFunc) HTTPClientOption {
+	return func(opts *httpClientOptions) {
+		opts.dialContextFunc = fn
+	}
+}
+
+// WithKeepAlivesDisabled disables HTTP keep-alives, so that connections are
+// used for a single request.
+func WithKeepAlivesDisabled() HTTPClientOption {
+	return func(opts *httpClientOptions) {
+		opts.keepAlivesDisabled = true
+	}
+}
+
+// WithConntrackDisabled disables the go-conntrack tracing and metrics of the
+// connections.
+func WithConntrackDisabled() HTTPClientOption {
+	return func(opts *httpClientOptions) {
+		opts.conntrackDisabled = true
+	}
+}
+
+// WithUserAgent sets the User-Agent header of the requests unless it has
+// already been set.
+func WithUserAgent(ua string) HTTPClientOption {
+	return func(opts *httpClientOptions) {
+		opts.userAgent = ua
+	}
+}
+
+// WithRoundTripperMiddleware wraps the RoundTripper built from the
+// configuration with the given functions. The first one is the outermost
+// wrapper, so it sees the requests first.
+func WithRoundTripperMiddleware(mws ...func(http.RoundTripper) http.RoundTripper) HTTPClientOption {
+	return func(opts *httpClientOptions) {
+		opts.middlewares = append(opts.middlewares, mws...)
+	}
+}
+
+// WithRegisterer registers metrics of the requests on reg: counts and
+// latencies by status code and method, requests in flight, DNS and TLS
+// handshake timings, and failures to reload the TLS files and the
+// credentials files. The metrics carry the name of the client
+// in the client label.
+func WithRegisterer(reg prometheus.Registerer) HTTPClientOption {
+	return func(opts *httpClientOptions) {
+		opts.registerer = reg
+	}
+}
+
+// WithCertificateCollector exports the validity periods of the CA and client
+// certificates in use by the round tripper through c, under the name of the
+// client. They replace the certificates of the previous round tripper created
+// with the same name, and are updated whenever the TLS files are reloaded.
+func WithCertificateCollector(c *CertificateCollector) HTTPClientOption {
+	return func(opts *httpClientOptions) {
+		opts.certificateCollector = c
+	}
+}
+
+// WithCredentialsFileErrorHook sets a function called with the errors reading
+// the credentials files, such as bearer_token_file. The requests keep using
+// the credentials last read successfully, and fail only if a file has never
+// been read.
+func WithCredentialsFileErrorHook(hook func(error)) HTTPClientOption {
+	return func(opts *httpClientOptions) {
+		opts.credentialsFileErrorHook = hook
+	}
+}
+
+// keepAlivesOptions returns the options matching the legacy
+// disableKeepAlives parameter.
+func keepAlivesOptions(disableKeepAlives bool) []HTTPClientOption {
+	if disableKeepAlives {
+		return []HTTPClientOption{WithKeepAlivesDisabled()}
+	}
+	return nil
+}
+
 // NewClient returns a http.Client using the specified http.RoundTripper.
-func newClient(rt http.RoundTripper) *http.Client {
-	return &http.Client{Transport: rt}
+// Redirects to or from unix sockets are never followed.
+func newClient(rt http.RoundTripper, followRedirects bool) *http.Client {
+	client := &http.Client{Transport: rt}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !followRedirects {
+			return http.ErrUseLastResponse
+		}
+		if req.URL.Scheme == "unix" || via[len(via)-1].URL.Scheme == "unix" {
+			return errors.New("redirects to or from unix sockets are not followed")
+		}
+		// The default policy of http.Client.
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	return client
 }
 
 // NewClientFromConfig returns a new HTTP client configured for the
 // given config.HTTPClientConfig. The name is used as go-conntrack metric label.
+// It is equivalent to NewClientFromConfigWithOptions with
+// WithKeepAlivesDisabled if disableKeepAlives is true.
 func NewClientFromConfig(cfg HTTPClientConfig, name string, disableKeepAlives bool) (*http.Client, error) {
-	rt, err := NewRoundTripperFromConfig(cfg, name, disableKeepAlives)
+	return NewClientFromConfigWithOptions(cfg, name, keepAlivesOptions(disableKeepAlives)...)
+}
+
+// NewClientFromConfigWithOptions returns a new HTTP client configured for the
+// given config.HTTPClientConfig and options. The name is used as go-conntrack
+// metric label and as client label of the metrics registered with
+// WithRegisterer. Unlike NewRoundTripperFromConfigWithOptions, it also applies
+// FollowRedirects.
+func NewClientFromConfigWithOptions(cfg HTTPClientConfig, name string, optFuncs ...HTTPClientOption) (*http.Client, error) {
+	rt, err := NewRoundTripperFromConfigWithOptions(cfg, name, optFuncs...)
 	if err != nil {
 		return nil, err
 	}
-	return newClient(rt), nil
+	return newClient(rt, boolOrDefault(cfg.FollowRedirects, true)), nil
 }
 
 // NewRoundTripperFromConfig returns a new HTTP RoundTripper configured for the
 // given config.HTTPClientConfig. The name is used as go-conntrack metric label.
+// It is equivalent to NewRoundTripperFromConfigWithOptions with
+// WithKeepAlivesDisabled if disableKeepAlives is true.
 func NewRoundTripperFromConfig(cfg HTTPClientConfig, name string, disableKeepAlives bool) (http.RoundTripper, error) {
+	return NewRoundTripperFromConfigWithOptions(cfg, name, keepAlivesOptions(disableKeepAlives)...)
+}
+
+// NewRoundTripperFromConfigWithOptions returns a new HTTP RoundTripper
+// configured for the given config.HTTPClientConfig and options. The name is
+// used as go-conntrack metric label and as client label of the metrics
+// registered with WithRegisterer.
+func NewRoundTripperFromConfigWithOptions(cfg HTTPClientConfig, name string, optFuncs ...HTTPClientOption) (http.RoundTripper, error) {
+	opts := &httpClientOptions{}
+	for _, f := range optFuncs {
+		f(opts)
+	}
+
+	var dialContext DialContextFunc = (&net.Dialer{Timeout: time.Duration(cfg.DialTimeout)}).DialContext
+	if opts.dialContextFunc != nil {
+		dialContext = withDialTimeout(opts.dialContextFunc, time.Duration(cfg.DialTimeout))
+	}
+	dialContext = withUnixSockets(dialContext)
+	if !opts.conntrackDisabled {
+		dialContext = conntrack.NewDialContextFunc(
+			conntrack.DialWithTracing(),
+			conntrack.DialWithName(name),
+			conntrack.DialWithDialContextFunc((func(context.Context, string, string) (net.Conn, error))(dialContext)),
+		)
+	}
+
+	limits := newRequestLimits(cfg.RateLimit, cfg.MaxConcurrentRequests)
+
+	var metrics *clientMetrics
+	if opts.registerer != nil {
+		var err error
+		metrics, err = newClientMetrics(opts.registerer, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// The credentials files are cached across the reloads of the TLS files.
+	files := credentialsFileOptions{
+		refreshInterval: time.Duration(cfg.CredentialsFileRefreshInterval),
+		onError:         metrics.reloadFailed("credentials_file", opts.credentialsFileErrorHook),
+	}
+	var (
+		bearerTokenFile   *credentialsFile
+		authorizationFile *credentialsFile
+		basicAuthFile     *credentialsFile
+		oauth2SecretFile  *credentialsFile
+		headerFiles       map[string][]*credentialsFile
+	)
+	if len(cfg.BearerTokenFile) > 0 {
+		bearerTokenFile = files.newCredentialsFile(cfg.BearerTokenFile, "bearer token file")
+	}
+	if cfg.Authorization != nil && len(cfg.Authorization.CredentialsFile) > 0 {
+		authorizationFile = files.newCredentialsFile(cfg.Authorization.CredentialsFile, "authorization credentials file")
+	}
+	if cfg.BasicAuth != nil && len(cfg.BasicAuth.PasswordFile) > 0 {
+		basicAuthFile = files.newCredentialsFile(cfg.BasicAuth.PasswordFile, "basic auth password file")
+	}
+	if cfg.OAuth2 != nil && len(cfg.OAuth2.ClientSecretFile) > 0 {
+		oauth2SecretFile = files.newCredentialsFile(cfg.OAuth2.ClientSecretFile, "oauth2 client secret file")
+	}
+	if len(cfg.HTTPHeaders) > 0 {
+		headerFiles = newHeaderFiles(cfg.HTTPHeaders, files)
+	}
+
 	newRT := func(tlsConfig *tls.Config) (http.RoundTripper, error) {
+		proxyConnectHeader, err := cfg.proxyConnectHeader()
+		if err != nil {
+			return nil, err
+		}
 		// The only timeout we care about is the configured scrape timeout.
-		// It is applied on request. So we leave out any timings here.
-		var rt http.RoundTripper = &http.Transport{
-			Proxy:               http.ProxyURL(cfg.ProxyURL.URL),
-			MaxIdleConns:        20000,
-			MaxIdleConnsPerHost: 1000, // see https://github.com/golang/go/issues/13801
-			DisableKeepAlives:   disableKeepAlives,
+		// It is applied on request. So we leave out any timings here by default.
+		transport := &http.Transport{
+			Proxy:               withoutUnixSocketProxy(cfg.proxyFunc()),
+			ProxyConnectHeader:  proxyConnectHeader,
+			MaxIdleConns:        orDefault(cfg.MaxIdleConns, 20000),
+			MaxIdleConnsPerHost: orDefault(cfg.MaxIdleConnsPerHost, 1000), // see https://github.com/golang/go/issues/13801
+			DisableKeepAlives:   opts.keepAlivesDisabled,
 			TLSClientConfig:     tlsConfig,
 			DisableCompression:  true,
 			// 5 minutes is typically above the maximum sane scrape interval. So we can
 			// use keepalive for all configurations.
-			IdleConnTimeout:       5 * time.Minute,
-			TLSHandshakeTimeout:   10 * time.Second,
+			IdleConnTimeout:       durationOrDefault(cfg.IdleConnTimeout, 5*time.Minute),
+			TLSHandshakeTimeout:   durationOrDefault(cfg.TLSHandshakeTimeout, 10*time.Second),
 			ExpectContinueTimeout: 1 * time.Second,
-			DialContext: conntrack.NewDialContextFunc(
-				conntrack.DialWithTracing(),
-				conntrack.DialWithName(name),
-			),
+			DialContext:           dialContext,
 		}
-		// TODO: use ForceAttemptHTTP2 when we move to Go 1.13+.
-		err := http2.ConfigureTransport(rt.(*http.Transport))
-		if err != nil {
-			return nil, err
+		transport.RegisterProtocol("unix", &unixSocketRoundTripper{transport})
+		if boolOrDefault(cfg.EnableHTTP2, true) {
+			// TODO: use ForceAttemptHTTP2 when we move to Go 1.13+.
+			err := http2.ConfigureTransport(transport)
+			if err != nil {
+				return nil, err
+			}
+		}
+		var rt http.RoundTripper = transport
+
+		// The signature covers the headers set by the other round trippers,
+		// so the request is signed last.
+		if cfg.SigV4 != nil {
+			var err error
+			rt, err = newSigV4RoundTripper(cfg.SigV4, rt, files)
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		// If a bearer token is provided, create a round tripper that will set the
 		// Authorization header correctly on each request.
-		if len(cfg.BearerToken) > 0 {
-			rt = NewBearerAuthRoundTripper(cfg.BearerToken, rt)
+		if !cfg.BearerToken.IsZero() {
+			rt = &authorizationCredentialsRoundTripper{authType: "Bearer", authCredentials: cfg.BearerToken, rt: rt}
 		} else if len(cfg.BearerTokenFile) > 0 {
-			rt = NewBearerAuthFileRoundTripper(cfg.BearerTokenFile, rt)
+			rt = &authorizationCredentialsFileRoundTripper{authType: "Bearer", file: bearerTokenFile, rt: rt}
+		}
+
+		if cfg.Authorization != nil {
+			authType := strings.TrimSpace(cfg.Authorization.Type)
+			if len(authType) == 0 {
+				authType = "Bearer"
+			}
+			if len(cfg.Authorization.CredentialsFile) > 0 {
+				rt = &authorizationCredentialsFileRoundTripper{authType: authType, file: authorizationFile, rt: rt}
+			} else {
+				rt = &authorizationCredentialsRoundTripper{authType: authType, authCredentials: cfg.Authorization.Credentials, rt: rt}
+			}
 		}
 
 		if cfg.BasicAuth != nil {
-			rt = NewBasicAuthRoundTripper(cfg.BasicAuth.Username, cfg.BasicAuth.Password, cfg.BasicAuth.PasswordFile, rt)
+			rt = &basicAuthRoundTripper{username: cfg.BasicAuth.Username, password: cfg.BasicAuth.Password, passwordFile: basicAuthFile, rt: rt}
+		}
+
+		if cfg.OAuth2 != nil {
+			rt = &oauth2RoundTripper{config: cfg.OAuth2, secretFile: oauth2SecretFile, next: rt}
+		}
+
+		if len(cfg.HTTPHeaders) > 0 {
+			rt = &headersRoundTripper{headers: cfg.HTTPHeaders, files: headerFiles, rt: rt}
+		}
+
+		if len(opts.userAgent) > 0 {
+			rt = NewUserAgentRoundTripper(opts.userAgent, rt)
+		}
+
+		if limits != nil {
+			rt = &limitRoundTripper{limits: limits, rt: rt}
+		}
+
+		// Retries go through the whole chain, so that every attempt is
+		// limited, authenticated and signed again.
+		if cfg.Retry != nil {
+			rt = NewRetryRoundTripper(cfg.Retry, rt)
 		}
 		// Return a new configured RoundTripper.
 		return rt, nil
@@ -180,66 +731,264 @@ func NewRoundTripperFromConfig(cfg HTTPClientConfig, name string, disableKeepAli
 		return nil, err
 	}
 
-	if len(cfg.TLSConfig.CAFile) == 0 {
-		// No need for a RoundTripper that reloads the CA file automatically.
-		return newRT(tlsConfig)
+	recordCerts := opts.certificateCollector.recorder(name, &cfg.TLSConfig)
+	var rt http.RoundTripper
+	if !cfg.TLSConfig.hasFiles() {
+		// No need for a RoundTripper that reloads the TLS files automatically.
+		rt, err = newRT(tlsConfig)
+		if err == nil && recordCerts != nil {
+			recordCerts([]byte(cfg.TLSConfig.CA), []byte(cfg.TLSConfig.Cert))
+		}
+	} else {
+		cfg.TLSConfig.ReloadHook = metrics.reloadFailed("tls", cfg.TLSConfig.ReloadHook)
+		rt, err = newTLSRoundTripper(tlsConfig, &cfg.TLSConfig, newRT, recordCerts, time.Duration(cfg.CredentialsFileRefreshInterval))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if metrics != nil {
+		rt = metrics.instrumentRoundTripper(rt)
+	}
+
+	for i := len(opts.middlewares) - 1; i >= 0; i-- {
+		rt = opts.middlewares[i](rt)
+	}
+	return rt, nil
+}
+
+// unixSocketHostPrefix is the prefix of the host names standing for unix
+// sockets in the requests sent through the transport. They keep the
+// connections to different sockets apart in the connection pool, the socket
+// itself is only taken from the request context.
+const unixSocketHostPrefix = "unix-socket-"
+
+// unixSocketContextKey is the key of the socket path in the context of the
+// requests sent by unixSocketRoundTripper.
+type unixSocketContextKey struct{}
+
+// unixSocketRoundTripper handles the requests to unix:// URLs. The URL path
+// holds the socket path, optionally followed by a colon and the HTTP path,
+// such as unix:///run/agent.sock:/metrics. The HTTP path defaults to "/".
+type unixSocketRoundTripper struct {
+	rt http.RoundTripper
+}
+
+func (rt *unixSocketRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	socket, path := req.URL.Path, "/"
+	if i := strings.Index(socket, ":"); i >= 0 {
+		socket, path = socket[:i], socket[i+1:]
+	}
+	if len(socket) == 0 {
+		return nil, fmt.Errorf("missing unix socket path in URL %s", req.URL)
+	}
+
+	req = req.WithContext(context.WithValue(req.Context(), unixSocketContextKey{}, socket))
+	u := *req.URL
+	u.Scheme = "http"
+	u.Host = unixSocketHost(socket)
+	u.Path, u.RawPath = path, ""
+	req.URL = &u
+	if len(req.Host) == 0 {
+		req.Host = "localhost"
+	}
+	return rt.rt.RoundTrip(req)
+}
+
+// unixSocketHost returns the host name standing for the socket.
+func unixSocketHost(socket string) string {
+	return unixSocketHostPrefix + hex.EncodeToString([]byte(socket))
+}
+
+// unixSocketPath returns the socket path set in the context by
+// unixSocketRoundTripper, provided that host stands for it.
+func unixSocketPath(ctx context.Context, host string) (string, bool) {
+	socket, ok := ctx.Value(unixSocketContextKey{}).(string)
+	if !ok || host != unixSocketHost(socket) {
+		return "", false
+	}
+	return socket, true
+}
+
+// withUnixSockets dials the unix sockets of the requests sent by
+// unixSocketRoundTripper and delegates other dials to fn.
+func withUnixSockets(fn DialContextFunc) DialContextFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			if socket, ok := unixSocketPath(ctx, host); ok {
+				return fn(ctx, "unix", socket)
+			}
+		}
+		return fn(ctx, network, addr)
+	}
+}
+
+// withoutUnixSocketProxy bypasses the proxy for the requests sent by
+// unixSocketRoundTripper. The other requests to the host names standing for
+// unix sockets, such as the redirects of a remote server, are refused before
+// they can reuse a pooled connection to a socket.
+func withoutUnixSocketProxy(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		host := req.URL.Hostname()
+		if _, ok := unixSocketPath(req.Context(), host); ok {
+			return nil, nil
+		}
+		if strings.HasPrefix(host, unixSocketHostPrefix) {
+			return nil, fmt.Errorf("invalid host %s", host)
+		}
+		return proxy(req)
+	}
+}
+
+// withDialTimeout bounds the duration of the dials made by fn, unless
+// timeout is zero.
+func withDialTimeout(fn DialContextFunc, timeout time.Duration) DialContextFunc {
+	if timeout == 0 {
+		return fn
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return fn(ctx, network, addr)
+	}
+}
+
+// proxyFunc returns the function selecting the proxy of the requests.
+func (c *HTTPClientConfig) proxyFunc() func(*http.Request) (*url.URL, error) {
+	var proxy func(*url.URL) (*url.URL, error)
+	switch {
+	case c.ProxyFromEnvironment:
+		proxy = httpproxy.FromEnvironment().ProxyFunc()
+	case len(c.NoProxy) > 0:
+		proxy = (&httpproxy.Config{
+			HTTPProxy:  c.ProxyURL.String(),
+			HTTPSProxy: c.ProxyURL.String(),
+			NoProxy:    c.NoProxy,
+		}).ProxyFunc()
+	default:
+		return http.ProxyURL(c.ProxyURL.URL)
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+}
+
+// proxyConnectHeader returns the headers to send to the proxy in CONNECT
+// requests. Secret references are resolved once, when called.
+func (c *HTTPClientConfig) proxyConnectHeader() (http.Header, error) {
+	if len(c.ProxyConnectHeader) == 0 {
+		return nil, nil
+	}
+	h := make(http.Header, len(c.ProxyConnectHeader))
+	for name, values := range c.ProxyConnectHeader {
+		for _, v := range values {
+			value, err := v.Resolve(context.Background())
+			if err != nil {
+				return nil, fmt.Errorf("unable to resolve proxy connect header %s: %s", name, err)
+			}
+			h.Add(name, value)
+		}
+	}
+	return h, nil
+}
+
+// orDefault returns v, or def if v is zero.
+func orDefault(v, def int) int {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+// boolOrDefault returns *b, or def if b is nil.
+func boolOrDefault(b *bool, def bool) bool {
+	if b == nil {
+		return def
+	}
+	return *b
+}
+
+// durationOrDefault returns d, or def if d is zero.
+func durationOrDefault(d model.Duration, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
 	}
+	return time.Duration(d)
+}
 
-	return newTLSRoundTripper(tlsConfig, cfg.TLSConfig.CAFile, newRT)
+type authorizationCredentialsRoundTripper struct {
+	authType        string
+	authCredentials SecretValue
+	rt              http.RoundTripper
 }
 
-type bearerAuthRoundTripper struct {
-	bearerToken Secret
-	rt          http.RoundTripper
+// NewAuthorizationCredentialsRoundTripper adds the provided credentials, with
+// the given authorization scheme, to a request unless the authorization header
+// has already been set.
+func NewAuthorizationCredentialsRoundTripper(authType string, authCredentials Secret, rt http.RoundTripper) http.RoundTripper {
+	return &authorizationCredentialsRoundTripper{authType, NewSecretValue(authCredentials), rt}
 }
 
 // NewBearerAuthRoundTripper adds the provided bearer token to a request unless the authorization
 // header has already been set.
 func NewBearerAuthRoundTripper(token Secret, rt http.RoundTripper) http.RoundTripper {
-	return &bearerAuthRoundTripper{token, rt}
+	return NewAuthorizationCredentialsRoundTripper("Bearer", token, rt)
 }
 
-func (rt *bearerAuthRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+func (rt *authorizationCredentialsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	if len(req.Header.Get("Authorization")) == 0 {
+		authCredentials, err := rt.authCredentials.Resolve(req.Context())
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve authorization credentials: %s", err)
+		}
 		req = cloneRequest(req)
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", string(rt.bearerToken)))
+		req.Header.Set("Authorization", fmt.Sprintf("%s %s", rt.authType, authCredentials))
 	}
 	return rt.rt.RoundTrip(req)
 }
 
-func (rt *bearerAuthRoundTripper) CloseIdleConnections() {
+func (rt *authorizationCredentialsRoundTripper) CloseIdleConnections() {
 	if ci, ok := rt.rt.(closeIdler); ok {
 		ci.CloseIdleConnections()
 	}
 }
 
-type bearerAuthFileRoundTripper struct {
-	bearerFile string
-	rt         http.RoundTripper
+type authorizationCredentialsFileRoundTripper struct {
+	authType string
+	file     *credentialsFile
+	rt       http.RoundTripper
+}
+
+// NewAuthorizationCredentialsFileRoundTripper adds the credentials read from
+// the provided file, with the given authorization scheme, to a request unless
+// the authorization header has already been set. The file is read again when
+// its modification time changes.
+func NewAuthorizationCredentialsFileRoundTripper(authType, authCredentialsFile string, rt http.RoundTripper) http.RoundTripper {
+	file := credentialsFileOptions{}.newCredentialsFile(authCredentialsFile, "authorization credentials file")
+	return &authorizationCredentialsFileRoundTripper{authType: authType, file: file, rt: rt}
 }
 
 // NewBearerAuthFileRoundTripper adds the bearer token read from the provided file to a request unless
-// the authorization header has already been set. This file is read for every request.
+// the authorization header has already been set. The file is read again when its modification time changes.
 func NewBearerAuthFileRoundTripper(bearerFile string, rt http.RoundTripper) http.RoundTripper {
-	return &bearerAuthFileRoundTripper{bearerFile, rt}
+	file := credentialsFileOptions{}.newCredentialsFile(bearerFile, "bearer token file")
+	return &authorizationCredentialsFileRoundTripper{authType: "Bearer", file: file, rt: rt}
 }
 
-func (rt *bearerAuthFileRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+func (rt *authorizationCredentialsFileRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	if len(req.Header.Get("Authorization")) == 0 {
-		b, err := ioutil.ReadFile(rt.bearerFile)
+		authCredentials, err := rt.file.read()
 		if err != nil {
-			return nil, fmt.Errorf("unable to read bearer token file %s: %s", rt.bearerFile, err)
+			return nil, err
 		}
-		bearerToken := strings.TrimSpace(string(b))
 
 		req = cloneRequest(req)
-		req.Header.Set("Authorization", "Bearer "+bearerToken)
+		req.Header.Set("Authorization", rt.authType+" "+authCredentials)
 	}
 
 	return rt.rt.RoundTrip(req)
 }
 
-func (rt *bearerAuthFileRoundTripper) CloseIdleConnections() {
+func (rt *authorizationCredentialsFileRoundTripper) CloseIdleConnections() {
 	if ci, ok := rt.rt.(closeIdler); ok {
 		ci.CloseIdleConnections()
 	}
@@ -247,31 +996,41 @@ func (rt *bearerAuthFileRoundTripper) CloseIdleConnections() {
 
 type basicAuthRoundTripper struct {
 	username     string
-	password     Secret
-	passwordFile string
+	password     SecretValue
+	passwordFile *credentialsFile
 	rt           http.RoundTripper
 }
 
 // NewBasicAuthRoundTripper will apply a BASIC auth authorization header to a request unless it has
-// already been set.
+// already been set. The password file, if any, is read again when its modification time changes.
 func NewBasicAuthRoundTripper(username string, password Secret, passwordFile string, rt http.RoundTripper) http.RoundTripper {
-	return &basicAuthRoundTripper{username, password, passwordFile, rt}
+	var file *credentialsFile
+	if passwordFile != "" {
+		file = credentialsFileOptions{}.newCredentialsFile(passwordFile, "basic auth password file")
+	}
+	return &basicAuthRoundTripper{username: username, password: NewSecretValue(password), passwordFile: file, rt: rt}
 }
 
 func (rt *basicAuthRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	if len(req.Header.Get("Authorization")) != 0 {
 		return rt.rt.RoundTrip(req)
 	}
-	req = cloneRequest(req)
-	if rt.passwordFile != "" {
-		bs, err := ioutil.ReadFile(rt.passwordFile)
+	var password string
+	if rt.passwordFile != nil {
+		var err error
+		password, err = rt.passwordFile.read()
 		if err != nil {
-			return nil, fmt.Errorf("unable to read basic auth password file %s: %s", rt.passwordFile, err)
+			return nil, err
 		}
-		req.SetBasicAuth(rt.username, strings.TrimSpace(string(bs)))
 	} else {
-		req.SetBasicAuth(rt.username, strings.TrimSpace(string(rt.password)))
+		var err error
+		password, err = rt.password.Resolve(req.Context())
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve basic auth password: %s", err)
+		}
 	}
+	req = cloneRequest(req)
+	req.SetBasicAuth(rt.username, strings.TrimSpace(password))
 	return rt.rt.RoundTrip(req)
 }
 
@@ -281,183 +1040,996 @@ func (rt *basicAuthRoundTripper) CloseIdleConnections() {
 	}
 }
 
-// cloneRequest returns a clone of the provided *http.Request.
-// The clone is a shallow copy of the struct and its Header map.
-func cloneRequest(r *http.Request) *http.Request {
-	// Shallow copy of the struct.
-	r2 := new(http.Request)
-	*r2 = *r
-	// Deep copy of the Header.
-	r2.Header = make(http.Header)
-	for k, s := range r.Header {
-		r2.Header[k] = s
+type oauth2RoundTripper struct {
+	config     *OAuth2
+	secretFile *credentialsFile
+	next       http.RoundTripper
+
+	mtx    sync.RWMutex
+	rt     http.RoundTripper
+	secret string
+}
+
+// NewOAuth2RoundTripper adds an OAuth2 access token, obtained with the client
+// credentials flow, to a request unless the authorization header has already
+// been set. Tokens are cached and refreshed once they expire. The token
+// requests are sent through next, so they share its TLS and proxy settings.
+// If a client secret file is configured, it is read again when its
+// modification time changes and the cached token is discarded whenever its
+// content changes.
+func NewOAuth2RoundTripper(cfg *OAuth2, next http.RoundTripper) http.RoundTripper {
+	var file *credentialsFile
+	if cfg.ClientSecretFile != "" {
+		file = credentialsFileOptions{}.newCredentialsFile(cfg.ClientSecretFile, "oauth2 client secret file")
 	}
-	return r2
+	return &oauth2RoundTripper{config: cfg, secretFile: file, next: next}
 }
 
-// NewTLSConfig creates a new tls.Config from the given TLSConfig.
-func NewTLSConfig(cfg *TLSConfig) (*tls.Config, error) {
-	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+func (rt *oauth2RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(req.Header.Get("Authorization")) != 0 {
+		return rt.next.RoundTrip(req)
+	}
 
-	// If a CA cert is provided then let's read it in so we can validate the
-	// scrape target's certificate properly.
-	if len(cfg.CAFile) > 0 {
-		b, err := readCAFile(cfg.CAFile)
+	secret, err := rt.config.ClientSecret.Resolve(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve oauth2 client secret: %s", err)
+	}
+	if rt.secretFile != nil {
+		secret, err = rt.secretFile.read()
 		if err != nil {
 			return nil, err
 		}
-		if !updateRootCA(tlsConfig, b) {
-			return nil, fmt.Errorf("unable to use specified CA cert %s", cfg.CAFile)
-		}
 	}
 
-	if len(cfg.ServerName) > 0 {
-		tlsConfig.ServerName = cfg.ServerName
-	}
-	// If a client cert & key is provided then configure TLS config accordingly.
-	if len(cfg.CertFile) > 0 && len(cfg.KeyFile) == 0 {
-		return nil, fmt.Errorf("client cert file %q specified without client key file", cfg.CertFile)
-	} else if len(cfg.KeyFile) > 0 && len(cfg.CertFile) == 0 {
-		return nil, fmt.Errorf("client key file %q specified without client cert file", cfg.KeyFile)
-	} else if len(cfg.CertFile) > 0 && len(cfg.KeyFile) > 0 {
-		// Verify that client cert and key are valid.
-		if _, err := cfg.getClientCertificate(nil); err != nil {
-			return nil, err
-		}
-		tlsConfig.GetClientCertificate = cfg.getClientCertificate
+	rt.mtx.RLock()
+	current, changed := rt.rt, secret != rt.secret
+	rt.mtx.RUnlock()
+	if current == nil || changed {
+		current = rt.newTokenRoundTripper(secret)
+		rt.mtx.Lock()
+		rt.rt = current
+		rt.secret = secret
+		rt.mtx.Unlock()
 	}
+	return current.RoundTrip(req)
+}
 
-	return tlsConfig, nil
+// newTokenRoundTripper returns an oauth2.Transport whose token source uses
+// the given client secret.
+func (rt *oauth2RoundTripper) newTokenRoundTripper(secret string) http.RoundTripper {
+	cfg := &clientcredentials.Config{
+		ClientID:       rt.config.ClientID,
+		ClientSecret:   secret,
+		Scopes:         rt.config.Scopes,
+		TokenURL:       rt.config.TokenURL,
+		EndpointParams: mapToValues(rt.config.EndpointParams),
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: rt.next})
+	return &oauth2.Transport{
+		Source: cfg.TokenSource(ctx),
+		Base:   rt.next,
+	}
 }
 
-// TLSConfig configures the options for TLS connections.
+func (rt *oauth2RoundTripper) CloseIdleConnections() {
+	if ci, ok := rt.next.(closeIdler); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+type userAgentRoundTripper struct {
+	userAgent string
+	rt        http.RoundTripper
+}
+
+// NewUserAgentRoundTripper adds the user agent to a request unless the
+// User-Agent header has already been set.
+func NewUserAgentRoundTripper(userAgent string, rt http.RoundTripper) http.RoundTripper {
+	return &userAgentRoundTripper{userAgent, rt}
+}
+
+func (rt *userAgentRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(req.Header.Get("User-Agent")) == 0 {
+		req = cloneRequest(req)
+		req.Header.Set("User-Agent", rt.userAgent)
+	}
+	return rt.rt.RoundTrip(req)
+}
+
+func (rt *userAgentRoundTripper) CloseIdleConnections() {
+	if ci, ok := rt.rt.(closeIdler); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+type headersRoundTripper struct {
+	headers map[string]Header
+	files   map[string][]*credentialsFile
+	rt      http.RoundTripper
+}
+
+// NewHeadersRoundTripper adds the provided headers to a request. The files
+// holding header values are read again when their modification time changes.
+func NewHeadersRoundTripper(headers map[string]Header, rt http.RoundTripper) http.RoundTripper {
+	return &headersRoundTripper{headers: headers, files: newHeaderFiles(headers, credentialsFileOptions{}), rt: rt}
+}
+
+// newHeaderFiles returns the caches of the files holding header values, by
+// header name.
+func newHeaderFiles(headers map[string]Header, opts credentialsFileOptions) map[string][]*credentialsFile {
+	files := map[string][]*credentialsFile{}
+	for name, h := range headers {
+		for _, f := range h.Files {
+			files[name] = append(files[name], opts.newCredentialsFile(f, fmt.Sprintf("header %s file", name)))
+		}
+	}
+	return files
+}
+
+func (rt *headersRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+	for name, h := range rt.headers {
+		for _, v := range h.Values {
+			req.Header.Add(name, v)
+		}
+		for _, v := range h.Secrets {
+			value, err := v.Resolve(req.Context())
+			if err != nil {
+				return nil, fmt.Errorf("unable to resolve header %s: %s", name, err)
+			}
+			req.Header.Add(name, value)
+		}
+		for _, f := range rt.files[name] {
+			value, err := f.read()
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Add(name, value)
+		}
+	}
+	return rt.rt.RoundTrip(req)
+}
+
+func (rt *headersRoundTripper) CloseIdleConnections() {
+	if ci, ok := rt.rt.(closeIdler); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+// mapToValues converts a map of single-valued parameters to url.Values.
+func mapToValues(m map[string]string) url.Values {
+	v := url.Values{}
+	for name, value := range m {
+		v.Set(name, value)
+	}
+	return v
+}
+
+// cloneRequest returns a clone of the provided *http.Request.
+// The clone is a shallow copy of the struct and its Header map.
+func cloneRequest(r *http.Request) *http.Request {
+	// Shallow copy of the struct.
+	r2 := new(http.Request)
+	*r2 = *r
+	// Deep copy of the Header.
+	r2.Header = make(http.Header)
+	for k, s := range r.Header {
+		r2.Header[k] = s
+	}
+	return r2
+}
+
+// NewTLSConfig creates a new tls.Config from the given TLSConfig.
+func NewTLSConfig(cfg *TLSConfig) (*tls.Config, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+
+	// If a CA cert is provided then let's read it in so we can validate the
+	// scrape target's certificate properly.
+	if cfg.hasCA() {
+		b, err := cfg.readCA(ioutil.ReadFile, readCADir)
+		if err != nil {
+			return nil, err
+		}
+		if err := cfg.updateRootCA(tlsConfig, b); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(cfg.ServerName) > 0 {
+		tlsConfig.ServerName = cfg.ServerName
+	}
+	tlsConfig.MinVersion = uint16(cfg.MinVersion)
+	tlsConfig.MaxVersion = uint16(cfg.MaxVersion)
+	for _, c := range cfg.CipherSuites {
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, uint16(c))
+	}
+	for _, c := range cfg.CurvePreferences {
+		tlsConfig.CurvePreferences = append(tlsConfig.CurvePreferences, tls.CurveID(c))
+	}
+	// If a client cert & key is provided then configure TLS config accordingly.
+	if cfg.hasCert() {
+		// Verify that client cert and key are valid.
+		cert, err := cfg.getClientCertificate(nil)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.hasInlineClientCert() {
+			// Inline cert and key can't change, so don't read and decrypt the
+			// key again on every TLS handshake.
+			tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return cert, nil
+			}
+		} else {
+			tlsConfig.GetClientCertificate = cfg.getClientCertificate
+		}
+	}
+	if len(cfg.PinnedSPKISHA256) > 0 || cfg.VerifyPeerCertificate != nil {
+		tlsConfig.VerifyPeerCertificate = cfg.verifyPeerCertificate()
+	}
+
+	return tlsConfig, nil
+}
+
+// TLSConfig configures the options for TLS connections.
 type TLSConfig struct {
 	// The CA cert to use for the targets.
-	CAFile string `yaml:"ca_file,omitempty"`
+	CA string `yaml:"ca,omitempty" json:"ca,omitempty"`
+	// The CA cert file to use for the targets.
+	CAFile string `yaml:"ca_file,omitempty" json:"ca_file,omitempty"`
+	// A directory of CA cert files, with the .pem or .crt extension, to use
+	// for the targets in addition to the CA cert or CA cert file.
+	CADir string `yaml:"ca_dir,omitempty" json:"ca_dir,omitempty"`
+	// Add the CA certs to the system cert pool instead of replacing it.
+	CAAppendSystem bool `yaml:"ca_append_system,omitempty" json:"ca_append_system,omitempty"`
+	// The client cert for the targets.
+	Cert string `yaml:"cert,omitempty" json:"cert,omitempty"`
 	// The client cert file for the targets.
-	CertFile string `yaml:"cert_file,omitempty"`
+	CertFile string `yaml:"cert_file,omitempty" json:"cert_file,omitempty"`
+	// The client key for the targets.
+	Key SecretValue `yaml:"key,omitempty" json:"key,omitempty"`
 	// The client key file for the targets.
-	KeyFile string `yaml:"key_file,omitempty"`
+	KeyFile string `yaml:"key_file,omitempty" json:"key_file,omitempty"`
+	// The password of the client key, if it is encrypted.
+	KeyPassword SecretValue `yaml:"key_password,omitempty" json:"key_password,omitempty"`
+	// The file holding the password of the client key, if it is encrypted.
+	KeyPasswordFile string `yaml:"key_password_file,omitempty" json:"key_password_file,omitempty"`
 	// Used to verify the hostname for the targets.
-	ServerName string `yaml:"server_name,omitempty"`
+	ServerName string `yaml:"server_name,omitempty" json:"server_name,omitempty"`
 	// Disable target certificate validation.
-	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify" json:"insecure_skip_verify"`
+	// Minimum acceptable TLS version.
+	MinVersion TLSVersion `yaml:"min_version,omitempty" json:"min_version,omitempty"`
+	// Maximum acceptable TLS version.
+	MaxVersion TLSVersion `yaml:"max_version,omitempty" json:"max_version,omitempty"`
+	// Cipher suites enabled for TLS 1.0 to 1.2. TLS 1.3 cipher suites are not
+	// configurable.
+	CipherSuites []TLSCipherSuite `yaml:"cipher_suites,omitempty" json:"cipher_suites,omitempty"`
+	// Elliptic curves used in an ECDHE handshake, in preference order.
+	CurvePreferences []TLSCurve `yaml:"curve_preferences,omitempty" json:"curve_preferences,omitempty"`
+	// Base64 encoded SHA-256 hashes of the subject public key info of the
+	// certificates accepted for the targets. A target must present a
+	// certificate of its verified chain matching one of them. Combined with
+	// InsecureSkipVerify, the chain isn't verified and the leaf certificate
+	// must match, which verifies self-signed certificates.
+	PinnedSPKISHA256 []string `yaml:"pinned_spki_sha256,omitempty" json:"pinned_spki_sha256,omitempty"`
+
+	// ReloadHook, if not nil, is called by the RoundTrippers created from
+	// this configuration whenever they detect changes to the CA, cert or key
+	// files. The error is nil if the new files are in use, otherwise it is
+	// the reason why the previous files are kept.
+	ReloadHook func(error) `yaml:"-" json:"-"`
+
+	// VerifyPeerCertificate, if not nil, is called after the certificate of
+	// the targets is verified and matches the pins, if any, like the
+	// VerifyPeerCertificate field of tls.Config.
+	VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error `yaml:"-" json:"-"`
 }
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
 func (c *TLSConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	type plain TLSConfig
-	return unmarshal((*plain)(c))
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (c *TLSConfig) UnmarshalJSON(b []byte) error {
+	return c.UnmarshalYAML(jsonUnmarshal(b))
 }
 
-// getClientCertificate reads the pair of client cert and key from disk and returns a tls.Certificate.
+// MarshalJSON implements the json.Marshaler interface.
+func (c TLSConfig) MarshalJSON() ([]byte, error) {
+	type plain TLSConfig
+	return json.Marshal(struct {
+		plain
+		Key         *SecretValue `json:"key,omitempty"`
+		KeyPassword *SecretValue `json:"key_password,omitempty"`
+	}{plain(c), omitEmptySecret(c.Key), omitEmptySecret(c.KeyPassword)})
+}
+
+// SetDirectory joins any relative file paths with dir.
+func (c *TLSConfig) SetDirectory(dir string) {
+	c.CAFile = JoinDir(dir, c.CAFile)
+	c.CADir = JoinDir(dir, c.CADir)
+	c.CertFile = JoinDir(dir, c.CertFile)
+	c.Key.SetDirectory(dir)
+	c.KeyFile = JoinDir(dir, c.KeyFile)
+	c.KeyPassword.SetDirectory(dir)
+	c.KeyPasswordFile = JoinDir(dir, c.KeyPasswordFile)
+}
+
+// Validate validates the TLSConfig to check that the CA, the client cert and
+// the client key and its password have at most one source each, that the
+// client cert and key are configured together, that a key password comes with
+// a key, that the TLS version range is not empty and that the pins are valid.
+func (c *TLSConfig) Validate() error {
+	if len(c.CA) > 0 && len(c.CAFile) > 0 {
+		return fmt.Errorf("at most one of ca & ca_file must be configured")
+	}
+	if len(c.Cert) > 0 && len(c.CertFile) > 0 {
+		return fmt.Errorf("at most one of cert & cert_file must be configured")
+	}
+	if !c.Key.IsZero() && len(c.KeyFile) > 0 {
+		return fmt.Errorf("at most one of key & key_file must be configured")
+	}
+	if c.hasCert() && !c.hasKey() {
+		return fmt.Errorf("client cert %s specified without client key", source(len(c.Cert) > 0, c.CertFile))
+	}
+	if c.hasKey() && !c.hasCert() {
+		return fmt.Errorf("client key %s specified without client cert", source(!c.Key.IsZero(), c.KeyFile))
+	}
+	if !c.KeyPassword.IsZero() && len(c.KeyPasswordFile) > 0 {
+		return fmt.Errorf("at most one of key_password & key_password_file must be configured")
+	}
+	if c.hasKeyPassword() && !c.hasKey() {
+		return fmt.Errorf("client key password %s specified without client key", source(!c.KeyPassword.IsZero(), c.KeyPasswordFile))
+	}
+	if c.MinVersion != 0 && c.MaxVersion != 0 && c.MinVersion > c.MaxVersion {
+		return fmt.Errorf("min_version %s must not be greater than max_version %s", c.MinVersion, c.MaxVersion)
+	}
+	for _, pin := range c.PinnedSPKISHA256 {
+		if _, err := decodeSPKIPin(pin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TLSVersion is a TLS protocol version, expressed as "TLS10" to "TLS13" in YAML.
+type TLSVersion uint16
+
+// versionTLS13 is tls.VersionTLS13, which is only available from Go 1.12.
+const versionTLS13 = 0x0304
+
+// tlsVersions maps the YAML names of TLS versions to their values.
+var tlsVersions = map[string]TLSVersion{
+	"TLS13": (TLSVersion)(versionTLS13),
+	"TLS12": (TLSVersion)(tls.VersionTLS12),
+	"TLS11": (TLSVersion)(tls.VersionTLS11),
+	"TLS10": (TLSVersion)(tls.VersionTLS10),
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for TLSVersions.
+func (v *TLSVersion) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	if tv, ok := tlsVersions[s]; ok {
+		*v = tv
+		return nil
+	}
+	return fmt.Errorf("unknown TLS version: %s", s)
+}
+
+// MarshalYAML implements the yaml.Marshaler interface for TLSVersions.
+func (v TLSVersion) MarshalYAML() (interface{}, error) {
+	if v == 0 {
+		return nil, nil
+	}
+	return v.String(), nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for TLSVersions.
+func (v *TLSVersion) UnmarshalJSON(b []byte) error {
+	if isJSONNull(b) {
+		return nil
+	}
+	return v.UnmarshalYAML(jsonUnmarshal(b))
+}
+
+// MarshalJSON implements the json.Marshaler interface for TLSVersions.
+func (v TLSVersion) MarshalJSON() ([]byte, error) {
+	return jsonMarshal(v)
+}
+
+func (v TLSVersion) String() string {
+	for name, tv := range tlsVersions {
+		if tv == v {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%04X", uint16(v))
+}
+
+// TLSCipherSuite is a TLS cipher suite, expressed by its IANA name such as
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" in YAML.
+type TLSCipherSuite uint16
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for TLSCipherSuites.
+func (c *TLSCipherSuite) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	if cs, ok := cipherSuites[s]; ok {
+		*c = cs
+		return nil
+	}
+	return fmt.Errorf("unknown TLS cipher suite: %s", s)
+}
+
+// MarshalYAML implements the yaml.Marshaler interface for TLSCipherSuites.
+func (c TLSCipherSuite) MarshalYAML() (interface{}, error) {
+	return c.String(), nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for TLSCipherSuites.
+func (c *TLSCipherSuite) UnmarshalJSON(b []byte) error {
+	if isJSONNull(b) {
+		return nil
+	}
+	return c.UnmarshalYAML(jsonUnmarshal(b))
+}
+
+// MarshalJSON implements the json.Marshaler interface for TLSCipherSuites.
+func (c TLSCipherSuite) MarshalJSON() ([]byte, error) {
+	return jsonMarshal(c)
+}
+
+func (c TLSCipherSuite) String() string {
+	return cipherSuiteName(uint16(c))
+}
+
+// TLSCurve is an elliptic curve used in ECDHE handshakes, expressed as
+// "CurveP256", "CurveP384", "CurveP521" or "X25519" in YAML.
+type TLSCurve tls.CurveID
+
+// tlsCurves maps the YAML names of elliptic curves to their values.
+var tlsCurves = map[string]TLSCurve{
+	"CurveP256": (TLSCurve)(tls.CurveP256),
+	"CurveP384": (TLSCurve)(tls.CurveP384),
+	"CurveP521": (TLSCurve)(tls.CurveP521),
+	"X25519":    (TLSCurve)(tls.X25519),
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for TLSCurves.
+func (c *TLSCurve) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	if curve, ok := tlsCurves[s]; ok {
+		*c = curve
+		return nil
+	}
+	return fmt.Errorf("unknown TLS curve: %s", s)
+}
+
+// MarshalYAML implements the yaml.Marshaler interface for TLSCurves.
+func (c TLSCurve) MarshalYAML() (interface{}, error) {
+	return c.String(), nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for TLSCurves.
+func (c *TLSCurve) UnmarshalJSON(b []byte) error {
+	if isJSONNull(b) {
+		return nil
+	}
+	return c.UnmarshalYAML(jsonUnmarshal(b))
+}
+
+// MarshalJSON implements the json.Marshaler interface for TLSCurves.
+func (c TLSCurve) MarshalJSON() ([]byte, error) {
+	return jsonMarshal(c)
+}
+
+func (c TLSCurve) String() string {
+	for name, curve := range tlsCurves {
+		if curve == c {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%04X", uint16(c))
+}
+
+func (c *TLSConfig) hasCA() bool   { return len(c.CA) > 0 || len(c.CAFile) > 0 || len(c.CADir) > 0 }
+func (c *TLSConfig) hasCert() bool { return len(c.Cert) > 0 || len(c.CertFile) > 0 }
+func (c *TLSConfig) hasKey() bool  { return !c.Key.IsZero() || len(c.KeyFile) > 0 }
+func (c *TLSConfig) hasKeyPassword() bool {
+	return !c.KeyPassword.IsZero() || len(c.KeyPasswordFile) > 0
+}
+
+// hasFiles returns true if any of the CA, client cert, client key or key
+// password is read from a file or is a secret reference, and can change.
+func (c *TLSConfig) hasFiles() bool {
+	_, _, keyRef := c.Key.Ref()
+	_, _, passwordRef := c.KeyPassword.Ref()
+	return len(c.CAFile) > 0 || len(c.CADir) > 0 || len(c.CertFile) > 0 || len(c.KeyFile) > 0 ||
+		len(c.KeyPasswordFile) > 0 || keyRef || passwordRef
+}
+
+// hasInlineClientCert returns true if the client cert, key and key password
+// are all inline, and can't change.
+func (c *TLSConfig) hasInlineClientCert() bool {
+	_, _, keyRef := c.Key.Ref()
+	_, _, passwordRef := c.KeyPassword.Ref()
+	return len(c.CertFile) == 0 && len(c.KeyFile) == 0 && len(c.KeyPasswordFile) == 0 && !keyRef && !passwordRef
+}
+
+// readCA returns the inline CA cert or reads it from disk with readFile,
+// followed by the CA certs of the CA directory read with readDir.
+func (c *TLSConfig) readCA(readFile, readDir func(string) ([]byte, error)) ([]byte, error) {
+	var b []byte
+	switch {
+	case len(c.CA) > 0:
+		b = []byte(c.CA)
+	case len(c.CAFile) > 0:
+		data, err := readFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load specified CA cert %s: %s", c.CAFile, err)
+		}
+		b = data
+	}
+	if len(c.CADir) > 0 {
+		data, err := readDir(c.CADir)
+		if err != nil {
+			return nil, err
+		}
+		b = append(append(b, '\n'), data...)
+	}
+	return b, nil
+}
+
+// caSource describes where the CA certs come from for error messages.
+func (c *TLSConfig) caSource() string {
+	var sources []string
+	if len(c.CA) > 0 || len(c.CAFile) > 0 {
+		sources = append(sources, source(len(c.CA) > 0, c.CAFile))
+	}
+	if len(c.CADir) > 0 {
+		sources = append(sources, c.CADir)
+	}
+	return strings.Join(sources, " & ")
+}
+
+// readCert returns the inline client cert or reads it from disk with readFile.
+func (c *TLSConfig) readCert(readFile func(string) ([]byte, error)) ([]byte, error) {
+	if len(c.Cert) > 0 {
+		return []byte(c.Cert), nil
+	}
+	data, err := readFile(c.CertFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load specified client cert %s: %s", c.CertFile, err)
+	}
+	return data, nil
+}
+
+// readKey returns the inline client key or reads it from disk with readFile.
+// The key may be encrypted, see decryptKey.
+func (c *TLSConfig) readKey(readFile func(string) ([]byte, error)) ([]byte, error) {
+	var b []byte
+	if !c.Key.IsZero() {
+		key, err := c.Key.Resolve(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve client key: %s", err)
+		}
+		b = []byte(key)
+	} else {
+		data, err := readFile(c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load specified client key %s: %s", c.KeyFile, err)
+		}
+		b = data
+	}
+	return b, nil
+}
+
+// decryptKey decrypts the client key with the key password if it is
+// encrypted.
+func (c *TLSConfig) decryptKey(b, password []byte) ([]byte, error) {
+	b, err := decryptPrivateKey(b, password)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt client key %s: %s", source(!c.Key.IsZero(), c.KeyFile), err)
+	}
+	return b, nil
+}
+
+// readKeyPassword returns the inline key password or reads it from disk with
+// readFile, trimmed of surrounding whitespace. It returns nil if no key
+// password is configured.
+func (c *TLSConfig) readKeyPassword(readFile func(string) ([]byte, error)) ([]byte, error) {
+	switch {
+	case !c.KeyPassword.IsZero():
+		password, err := c.KeyPassword.Resolve(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve client key password: %s", err)
+		}
+		return []byte(password), nil
+	case len(c.KeyPasswordFile) > 0:
+		data, err := readFile(c.KeyPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load specified client key password %s: %s", c.KeyPasswordFile, err)
+		}
+		return bytes.TrimSpace(data), nil
+	}
+	return nil, nil
+}
+
+// source describes where a TLS item comes from for error messages.
+func source(inline bool, file string) string {
+	if inline {
+		return "<inline>"
+	}
+	return file
+}
+
+// getClientCertificate reads the pair of client cert and key and returns a tls.Certificate.
 func (c *TLSConfig) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
-	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	cert, err := c.loadX509KeyPair()
 	if err != nil {
-		return nil, fmt.Errorf("unable to use specified client cert (%s) & key (%s): %s", c.CertFile, c.KeyFile, err)
+		return nil, err
 	}
 	return &cert, nil
 }
 
-// readCAFile reads the CA cert file from disk.
-func readCAFile(f string) ([]byte, error) {
-	data, err := ioutil.ReadFile(f)
+// loadX509KeyPair reads the pair of client cert and key and parses them.
+func (c *TLSConfig) loadX509KeyPair() (tls.Certificate, error) {
+	certPEM, err := c.readCert(ioutil.ReadFile)
 	if err != nil {
-		return nil, fmt.Errorf("unable to load specified CA cert %s: %s", f, err)
+		return tls.Certificate{}, c.clientCertError(err)
 	}
-	return data, nil
+	keyPEM, err := c.readKey(ioutil.ReadFile)
+	if err != nil {
+		return tls.Certificate{}, c.clientCertError(err)
+	}
+	password, err := c.readKeyPassword(ioutil.ReadFile)
+	if err != nil {
+		return tls.Certificate{}, c.clientCertError(err)
+	}
+	keyPEM, err = c.decryptKey(keyPEM, password)
+	if err != nil {
+		return tls.Certificate{}, c.clientCertError(err)
+	}
+	return c.x509KeyPair(certPEM, keyPEM)
 }
 
-// updateRootCA parses the given byte slice as a series of PEM encoded certificates and updates tls.Config.RootCAs.
-func updateRootCA(cfg *tls.Config, b []byte) bool {
+// x509KeyPair parses the given pair of client cert and key.
+func (c *TLSConfig) x509KeyPair(certPEM, keyPEM []byte) (tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, c.clientCertError(err)
+	}
+	return cert, nil
+}
+
+// clientCertError annotates an error about the client cert & key with their sources.
+func (c *TLSConfig) clientCertError(err error) error {
+	return fmt.Errorf("unable to use specified client cert (%s) & key (%s): %s",
+		source(len(c.Cert) > 0, c.CertFile), source(!c.Key.IsZero(), c.KeyFile), err)
+}
+
+// CADirFiles returns the paths of the CA cert files of the directory, as used
+// by ca_dir: the files with the .pem or .crt extension which aren't hidden, in
+// the order of their names.
+func CADirFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, fi := range infos {
+		name := fi.Name()
+		if ext := filepath.Ext(name); strings.HasPrefix(name, ".") || (ext != ".pem" && ext != ".crt") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	return files, nil
+}
+
+// readCADir reads the CA cert files of the directory from disk.
+func readCADir(dir string) ([]byte, error) {
+	files, err := CADirFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load specified CA dir %s: %s", dir, err)
+	}
+	return readCAFiles(files)
+}
+
+// readCAFiles reads the CA cert files from disk and checks that each of them
+// holds certificates.
+func readCAFiles(files []string) ([]byte, error) {
+	var b []byte
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load CA cert %s: %s", f, err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("unable to use CA cert %s", f)
+		}
+		b = append(append(b, data...), '\n')
+	}
+	return b, nil
+}
+
+// updateRootCA parses the given byte slice as a series of PEM encoded
+// certificates and updates tls.Config.RootCAs, adding them to the system cert
+// pool if CAAppendSystem is set.
+func (c *TLSConfig) updateRootCA(cfg *tls.Config, b []byte) error {
 	caCertPool := x509.NewCertPool()
-	if !caCertPool.AppendCertsFromPEM(b) {
-		return false
+	if c.CAAppendSystem {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return fmt.Errorf("unable to load the system cert pool: %s", err)
+		}
+		caCertPool = pool
+	}
+	// The CA directory may be empty, its files are checked when read.
+	if !caCertPool.AppendCertsFromPEM(b) && (len(c.CA) > 0 || len(c.CAFile) > 0) {
+		return fmt.Errorf("unable to use specified CA cert %s", c.caSource())
 	}
 	cfg.RootCAs = caCertPool
-	return true
+	return nil
 }
 
 // tlsRoundTripper is a RoundTripper that updates automatically its TLS
-// configuration whenever the content of the CA file changes.
+// configuration whenever the content of the CA, cert or key files changes.
+// The files are reloaded together and only swapped in when they form a
+// consistent set, otherwise the last good set keeps being used.
 type tlsRoundTripper struct {
-	caFile string
+	cfg *TLSConfig
+	// onReload is called after every attempt to use changed files.
+	onReload func(error)
 	// newRT returns a new RoundTripper.
 	newRT func(*tls.Config) (http.RoundTripper, error)
+	// recordCerts, if not nil, is called with the CA and client certs in use
+	// whenever they change.
+	recordCerts func(ca, cert []byte)
+	// files caches the CA, cert, key and key password files by name.
+	files map[string]*credentialsFile
+	caDir *caDirCache
 
 	mtx        sync.RWMutex
 	rt         http.RoundTripper
-	hashCAFile []byte
+	hashFiles  []byte
+	hashFailed []byte
 	tlsConfig  *tls.Config
 }
 
 func newTLSRoundTripper(
 	cfg *tls.Config,
-	caFile string,
+	tlsCfg *TLSConfig,
 	newRT func(*tls.Config) (http.RoundTripper, error),
+	recordCerts func(ca, cert []byte),
+	refreshInterval time.Duration,
 ) (http.RoundTripper, error) {
 	t := &tlsRoundTripper{
-		caFile:    caFile,
-		newRT:     newRT,
-		tlsConfig: cfg,
+		cfg:         tlsCfg,
+		onReload:    tlsCfg.ReloadHook,
+		newRT:       newRT,
+		recordCerts: recordCerts,
+		files:       map[string]*credentialsFile{},
+		caDir:       &caDirCache{refreshInterval: refreshInterval, now: time.Now},
+		tlsConfig:   cfg,
+	}
+	opts := credentialsFileOptions{refreshInterval: refreshInterval}
+	for _, f := range []struct{ filename, description string }{
+		{tlsCfg.CAFile, "CA cert file"},
+		{tlsCfg.CertFile, "client cert file"},
+		{tlsCfg.KeyFile, "client key file"},
+		{tlsCfg.KeyPasswordFile, "client key password file"},
+	} {
+		if len(f.filename) > 0 && t.files[f.filename] == nil {
+			t.files[f.filename] = opts.newCredentialsFile(f.filename, f.description)
+		}
 	}
 
-	rt, err := t.newRT(t.tlsConfig)
+	f := t.readFiles()
+	rt, err := t.newRoundTripper(f)
 	if err != nil {
 		return nil, err
 	}
 	t.rt = rt
-
-	_, t.hashCAFile, err = t.getCAWithHash()
-	if err != nil {
-		return nil, err
+	t.hashFiles = f.hash
+	if t.recordCerts != nil {
+		t.recordCerts(f.ca, f.cert)
 	}
 
 	return t, nil
 }
 
-func (t *tlsRoundTripper) getCAWithHash() ([]byte, []byte, error) {
-	b, err := readCAFile(t.caFile)
+// caDirCache caches the CA certs of the CA directory. The directory is listed
+// again at most once per refresh interval, and its files are read again only
+// when their names, modification times or sizes change.
+type caDirCache struct {
+	refreshInterval time.Duration
+	now             func() time.Time
+
+	mtx     sync.Mutex
+	loaded  bool
+	checked time.Time
+	stamps  string
+	content []byte
+	err     error
+}
+
+// read returns the CA certs of the directory, or the error reading them.
+func (c *caDirCache) read(dir string) ([]byte, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := c.now()
+	if c.loaded && now.Sub(c.checked) < c.refreshInterval {
+		return c.content, c.err
+	}
+	c.checked = now
+
+	files, err := CADirFiles(dir)
 	if err != nil {
-		return nil, nil, err
+		c.loaded, c.stamps = true, ""
+		c.content, c.err = nil, fmt.Errorf("unable to load specified CA dir %s: %s", dir, err)
+		return c.content, c.err
+	}
+	var stamps bytes.Buffer
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			fmt.Fprintf(&stamps, "%s:%s\n", f, err)
+			continue
+		}
+		fmt.Fprintf(&stamps, "%s:%d:%d\n", f, fi.ModTime().UnixNano(), fi.Size())
+	}
+	if c.loaded && stamps.String() == c.stamps {
+		return c.content, c.err
 	}
-	h := md5.Sum(b)
-	return b, h[:], nil
+	c.loaded, c.stamps = true, stamps.String()
+	c.content, c.err = readCAFiles(files)
+	return c.content, c.err
+}
 
+// tlsFiles holds the content of the CA, cert, key and key password files read
+// at a given time along with any error encountered while reading them. The
+// key is still encrypted, it is only decrypted when the files change.
+type tlsFiles struct {
+	ca, cert, key, keyPassword []byte
+	err                        error
+	// hash identifies the content of the files, or the read errors.
+	hash []byte
 }
 
-// RoundTrip implements the http.RoundTrip interface.
-func (t *tlsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	b, h, err := t.getCAWithHash()
-	if err != nil {
-		return nil, err
+// readFile returns the content of one of the CA, cert, key and key password
+// files. The files are read again only when they change, see credentialsFile.
+func (t *tlsRoundTripper) readFile(filename string) ([]byte, error) {
+	return t.files[filename].readBytes()
+}
+
+// readFiles reads the CA, cert, key and key password files through their
+// caches. Inline items are used as they are.
+func (t *tlsRoundTripper) readFiles() *tlsFiles {
+	var (
+		f = &tlsFiles{}
+		h = md5.New()
+	)
+	read := func(configured bool, dst *[]byte, readFn func() ([]byte, error)) {
+		if !configured {
+			return
+		}
+		b, err := readFn()
+		if err != nil {
+			if f.err == nil {
+				f.err = err
+			}
+			h.Write([]byte(err.Error()))
+			return
+		}
+		s := md5.Sum(b)
+		h.Write(s[:])
+		*dst = b
+	}
+	read(t.cfg.hasCA(), &f.ca, func() ([]byte, error) { return t.cfg.readCA(t.readFile, t.caDir.read) })
+	read(t.cfg.hasCert(), &f.cert, func() ([]byte, error) { return t.cfg.readCert(t.readFile) })
+	read(t.cfg.hasKey(), &f.key, func() ([]byte, error) { return t.cfg.readKey(t.readFile) })
+	read(t.cfg.hasKeyPassword(), &f.keyPassword, func() ([]byte, error) { return t.cfg.readKeyPassword(t.readFile) })
+	f.hash = h.Sum(nil)
+	return f
+}
+
+// newRoundTripper returns a RoundTripper using the given files, provided they
+// are valid and the client cert matches the client key.
+func (t *tlsRoundTripper) newRoundTripper(f *tlsFiles) (http.RoundTripper, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	tlsConfig := t.tlsConfig.Clone()
+	if t.cfg.hasCA() {
+		if err := t.cfg.updateRootCA(tlsConfig, f.ca); err != nil {
+			return nil, err
+		}
+	}
+	if t.cfg.hasCert() {
+		key, err := t.cfg.decryptKey(f.key, f.keyPassword)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := t.cfg.x509KeyPair(f.cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &cert, nil
+		}
 	}
+	return t.newRT(tlsConfig)
+}
+
+// reload swaps in a new RoundTripper if the files have changed since they were
+// last used and are consistent. Failures are reported once per distinct set of
+// files and the current RoundTripper is left in place.
+func (t *tlsRoundTripper) reload() {
+	f := t.readFiles()
 
 	t.mtx.RLock()
-	equal := bytes.Equal(h[:], t.hashCAFile)
-	rt := t.rt
+	skip := bytes.Equal(f.hash, t.hashFiles) || bytes.Equal(f.hash, t.hashFailed)
 	t.mtx.RUnlock()
-	if equal {
-		// The CA cert hasn't changed, use the existing RoundTripper.
-		return rt.RoundTrip(req)
+	if skip {
+		return
 	}
 
-	// Create a new RoundTripper.
-	tlsConfig := t.tlsConfig.Clone()
-	if !updateRootCA(tlsConfig, b) {
-		return nil, fmt.Errorf("unable to use specified CA cert %s", t.caFile)
+	rt, err := t.newRoundTripper(f)
+
+	t.mtx.Lock()
+	if bytes.Equal(f.hash, t.hashFiles) || bytes.Equal(f.hash, t.hashFailed) {
+		// Another request has already handled these files.
+		t.mtx.Unlock()
+		return
 	}
-	rt, err = t.newRT(tlsConfig)
+	var old http.RoundTripper
 	if err != nil {
-		return nil, err
+		t.hashFailed = f.hash
+	} else {
+		old = t.rt
+		t.rt = rt
+		t.hashFiles = f.hash
+		t.hashFailed = nil
+		if t.recordCerts != nil {
+			t.recordCerts(f.ca, f.cert)
+		}
 	}
-	t.CloseIdleConnections()
-
-	t.mtx.Lock()
-	t.rt = rt
-	t.hashCAFile = h[:]
 	t.mtx.Unlock()
 
+	if ci, ok := old.(closeIdler); ok {
+		ci.CloseIdleConnections()
+	}
+	if t.onReload != nil {
+		t.onReload(err)
+	}
+}
+
+// RoundTrip implements the http.RoundTrip interface.
+func (t *tlsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.reload()
+
+	t.mtx.RLock()
+	rt := t.rt
+	t.mtx.RUnlock()
 	return rt.RoundTrip(req)
 }
 
@@ -16,12 +16,16 @@
 package config
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -32,6 +36,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/common/model"
 	yaml "gopkg.in/yaml.v2"
 )
 
@@ -49,6 +54,12 @@ const (
 	MissingCert           = "missing/cert.crt"
 	MissingKey            = "missing/secret.key"
 
+	ClientKeyPKCS8AES256Path  = "testdata/client-pkcs8-aes256.key"
+	ClientKeyPKCS8DES3Path    = "testdata/client-pkcs8-des3.key"
+	ClientKeyPKCS8PBES1Path   = "testdata/client-pkcs8-pbes1.key"
+	ClientKeyLegacyAES128Path = "testdata/client-legacy-aes128.key"
+	ClientKeyPasswordPath     = "testdata/client-key-password"
+
 	ExpectedMessage        = "I'm here to serve you!!!"
 	BearerToken            = "theanswertothegreatquestionoflifetheuniverseandeverythingisfortytwo"
 	BearerTokenFile        = "testdata/bearer.token"
@@ -74,6 +85,86 @@ var invalidHTTPClientConfigs = []struct {
 		httpClientConfigFile: "testdata/http.conf.basic-auth.too-much.bad.yaml",
 		errMsg:               "at most one of basic_auth password & password_file must be configured",
 	},
+	{
+		httpClientConfigFile: "testdata/http.conf.oauth2-secret-and-file-set.bad.yml",
+		errMsg:               "at most one of oauth2 client_secret & client_secret_file must be configured",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.oauth2-and-basic-auth.bad.yml",
+		errMsg:               "at most one of basic_auth, oauth2, bearer_token & bearer_token_file must be configured",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.oauth2-no-token-url.bad.yml",
+		errMsg:               "oauth2 token_url must be configured",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.headers-reserved.bad.yml",
+		errMsg:               `setting header "Authorization" is not allowed`,
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.authorization-and-bearer.bad.yml",
+		errMsg:               "authorization is not compatible with bearer_token & bearer_token_file",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.authorization-and-basic-auth.bad.yml",
+		errMsg:               "at most one of basic_auth, oauth2 & authorization must be configured",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.authorization-credentials-and-file.bad.yml",
+		errMsg:               "at most one of authorization credentials & credentials_file must be configured",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.authorization-basic.bad.yml",
+		errMsg:               `authorization type cannot be set to "basic", use "basic_auth" instead`,
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.negative-idle-conns.bad.yml",
+		errMsg:               "max_idle_conns must not be negative",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.proxy-from-env-and-url.bad.yml",
+		errMsg:               "if proxy_from_environment is configured, proxy_url must not be configured",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.proxy-from-env-and-no-proxy.bad.yml",
+		errMsg:               "if proxy_from_environment is configured, no_proxy must not be configured",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.no-proxy-without-proxy-url.bad.yml",
+		errMsg:               "if no_proxy is configured, proxy_url must also be configured",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.proxy-connect-header-without-proxy.bad.yml",
+		errMsg:               "if proxy_connect_header is configured, proxy_url or proxy_from_environment must also be configured",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.sigv4-access-key-without-secret.bad.yml",
+		errMsg:               "sigv4 access_key & secret_key must be configured together",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.sigv4-and-basic-auth.bad.yml",
+		errMsg:               "sigv4 is not compatible with basic_auth, authorization, oauth2, bearer_token & bearer_token_file",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.retry-backoff.bad.yml",
+		errMsg:               "retry min_backoff 1m must not be greater than max_backoff 10s",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.retry-status-code.bad.yml",
+		errMsg:               "invalid retry status code 1000",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.rate-limit-without-rps.bad.yml",
+		errMsg:               "rate_limit requests_per_second must be positive",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.negative-max-concurrent-requests.bad.yml",
+		errMsg:               "max_concurrent_requests must not be negative",
+	},
+	{
+		httpClientConfigFile: "testdata/http.conf.unknown-secret-provider.bad.yml",
+		errMsg:               `unknown secret provider "vault"`,
+	},
 }
 
 func newTestServer(handler func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, error) {
@@ -134,7 +225,7 @@ func TestNewClientFromConfig(t *testing.T) {
 			},
 		}, {
 			clientConfig: HTTPClientConfig{
-				BearerToken: BearerToken,
+				BearerToken: NewSecretValue(BearerToken),
 				TLSConfig: TLSConfig{
 					CAFile:             TLSCAChainPath,
 					CertFile:           ClientCertificatePath,
@@ -174,7 +265,7 @@ func TestNewClientFromConfig(t *testing.T) {
 			clientConfig: HTTPClientConfig{
 				BasicAuth: &BasicAuth{
 					Username: ExpectedUsername,
-					Password: ExpectedPassword,
+					Password: NewSecretValue(ExpectedPassword),
 				},
 				TLSConfig: TLSConfig{
 					CAFile:             TLSCAChainPath,
@@ -369,6 +460,455 @@ func TestBearerAuthFileRoundTripper(t *testing.T) {
 	}
 }
 
+func TestAuthorization(t *testing.T) {
+	testCases := []struct {
+		authorization Authorization
+		expected      string
+	}{
+		{
+			authorization: Authorization{Type: "Token", Credentials: NewSecretValue("mysecret")},
+			expected:      "Token mysecret",
+		},
+		{
+			authorization: Authorization{Credentials: NewSecretValue("mysecret")},
+			expected:      "Bearer mysecret",
+		},
+		{
+			authorization: Authorization{Type: "ApiKey", CredentialsFile: BearerTokenFile},
+			expected:      "ApiKey " + BearerToken,
+		},
+	}
+
+	for _, tc := range testCases {
+		testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if got := r.Header.Get("Authorization"); got != tc.expected {
+				fmt.Fprintf(w, "The expected Authorization (%s) differs from the obtained Authorization (%s)", tc.expected, got)
+				return
+			}
+			fmt.Fprint(w, ExpectedMessage)
+		}))
+
+		authorization := tc.authorization
+		client, err := NewClientFromConfig(HTTPClientConfig{Authorization: &authorization}, "test", false)
+		if err != nil {
+			t.Fatalf("Error creating HTTP Client: %v", err)
+		}
+		r, err := client.Get(testServer.URL)
+		if err != nil {
+			t.Fatalf("Can't connect to the test server: %s", err)
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		testServer.Close()
+		if err != nil {
+			t.Fatalf("Can't read the server response body: %s", err)
+		}
+		if got := strings.TrimSpace(string(b)); got != ExpectedMessage {
+			t.Errorf("The expected message %q differs from the obtained message %q", ExpectedMessage, got)
+		}
+	}
+
+	cfg, _, err := LoadHTTPConfigFile("testdata/http.conf.authorization.good.yml")
+	if err != nil {
+		t.Fatalf("Error loading HTTP client config: %v", err)
+	}
+	if cfg.Authorization.Type != "Token" || cfg.Authorization.Credentials != NewSecretValue("mysecret") {
+		t.Errorf("Unexpected authorization %+v", cfg.Authorization)
+	}
+	if strings.Contains(cfg.String(), "mysecret") {
+		t.Fatal("http client config's String method reveals authorization credentials.")
+	}
+}
+
+func TestFollowRedirects(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, ExpectedMessage)
+	}))
+	defer testServer.Close()
+
+	for _, follow := range []bool{true, false} {
+		follow := follow
+		client, err := NewClientFromConfig(HTTPClientConfig{FollowRedirects: &follow}, "test", false)
+		if err != nil {
+			t.Fatalf("Error creating HTTP Client: %v", err)
+		}
+		r, err := client.Get(testServer.URL + "/redirect")
+		if err != nil {
+			t.Fatalf("Can't connect to the test server: %s", err)
+		}
+		r.Body.Close()
+		expected := http.StatusOK
+		if !follow {
+			expected = http.StatusFound
+		}
+		if r.StatusCode != expected {
+			t.Errorf("follow_redirects=%t: expected status code %d, got %d", follow, expected, r.StatusCode)
+		}
+	}
+}
+
+func TestEnableHTTP2(t *testing.T) {
+	testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+	testServer.EnableHTTP2 = true
+	testServer.StartTLS()
+	defer testServer.Close()
+
+	for _, enable := range []bool{true, false} {
+		enable := enable
+		client, err := NewClientFromConfig(HTTPClientConfig{
+			EnableHTTP2: &enable,
+			TLSConfig:   TLSConfig{InsecureSkipVerify: true},
+		}, "test", false)
+		if err != nil {
+			t.Fatalf("Error creating HTTP Client: %v", err)
+		}
+		r, err := client.Get(testServer.URL)
+		if err != nil {
+			t.Fatalf("Can't connect to the test server: %s", err)
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			t.Fatalf("Can't read the server response body: %s", err)
+		}
+		expected := "HTTP/1.1"
+		if enable {
+			expected = "HTTP/2.0"
+		}
+		if string(b) != expected {
+			t.Errorf("enable_http2=%t: expected protocol %s, got %s", enable, expected, b)
+		}
+	}
+}
+
+func TestTransportSettings(t *testing.T) {
+	cfg, _, err := LoadHTTPConfigFile("testdata/http.conf.transport.good.yml")
+	if err != nil {
+		t.Fatalf("Error loading HTTP client config: %v", err)
+	}
+	rt, err := NewRoundTripperFromConfig(*cfg, "test", false)
+	if err != nil {
+		t.Fatalf("Error creating round tripper: %v", err)
+	}
+	transport, ok := rt.(*http.Transport)
+	if !ok {
+		t.Fatalf("Error casting to http.Transport, %v", rt)
+	}
+	if transport.MaxIdleConns != 10 {
+		t.Errorf("Expected MaxIdleConns 10, got %d", transport.MaxIdleConns)
+	}
+	if transport.MaxIdleConnsPerHost != 2 {
+		t.Errorf("Expected MaxIdleConnsPerHost 2, got %d", transport.MaxIdleConnsPerHost)
+	}
+	if transport.IdleConnTimeout != 30*time.Second {
+		t.Errorf("Expected IdleConnTimeout 30s, got %s", transport.IdleConnTimeout)
+	}
+	if transport.TLSHandshakeTimeout != 3*time.Second {
+		t.Errorf("Expected TLSHandshakeTimeout 3s, got %s", transport.TLSHandshakeTimeout)
+	}
+
+}
+
+func TestZeroHTTPClientConfigDefaults(t *testing.T) {
+	testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, r.Proto)
+	}))
+	testServer.EnableHTTP2 = true
+	testServer.StartTLS()
+	defer testServer.Close()
+
+	// A configuration built in Go follows redirects and negotiates HTTP/2,
+	// like one loaded from an empty YAML document.
+	cfg, err := LoadHTTPConfig("{}")
+	if err != nil {
+		t.Fatalf("Error loading HTTP client config: %v", err)
+	}
+	for _, cfg := range []HTTPClientConfig{
+		{TLSConfig: TLSConfig{InsecureSkipVerify: true}},
+		{TLSConfig: TLSConfig{InsecureSkipVerify: true}, FollowRedirects: cfg.FollowRedirects, EnableHTTP2: cfg.EnableHTTP2},
+	} {
+		client, err := NewClientFromConfig(cfg, "test", false)
+		if err != nil {
+			t.Fatalf("Error creating HTTP Client: %v", err)
+		}
+		r, err := client.Get(testServer.URL + "/redirect")
+		if err != nil {
+			t.Fatalf("Can't connect to the test server: %s", err)
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			t.Fatalf("Can't read the server response body: %s", err)
+		}
+		if r.StatusCode != http.StatusOK {
+			t.Errorf("Expected the redirect to be followed, got status code %d", r.StatusCode)
+		}
+		if string(b) != "HTTP/2.0" {
+			t.Errorf("Expected protocol HTTP/2.0, got %s", b)
+		}
+	}
+}
+
+func TestProxyConfiguration(t *testing.T) {
+	cfg, _, err := LoadHTTPConfigFile("testdata/http.conf.proxy.good.yml")
+	if err != nil {
+		t.Fatalf("Error loading HTTP client config: %v", err)
+	}
+	rt, err := NewRoundTripperFromConfig(*cfg, "test", false)
+	if err != nil {
+		t.Fatalf("Error creating round tripper: %v", err)
+	}
+	transport, ok := rt.(*http.Transport)
+	if !ok {
+		t.Fatalf("Error casting to http.Transport, %v", rt)
+	}
+	if got := transport.ProxyConnectHeader.Get("Proxy-Authorization"); got != "Basic dXNlcjpwYXNz" {
+		t.Errorf("Expected Proxy-Authorization header %q, got %q", "Basic dXNlcjpwYXNz", got)
+	}
+
+	checkProxy := func(transport *http.Transport, target, expected string) {
+		t.Helper()
+		req, err := http.NewRequest("GET", target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := transport.Proxy(req)
+		if err != nil {
+			t.Fatalf("Unexpected error selecting proxy for %s: %v", target, err)
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != expected {
+			t.Errorf("Expected proxy %q for %s, got %q", expected, target, got)
+		}
+	}
+	checkProxy(transport, "http://www.example.org/metrics", "http://proxy.example.com:3128")
+	checkProxy(transport, "https://www.example.org/metrics", "http://proxy.example.com:3128")
+	checkProxy(transport, "http://internal.example.com/metrics", "")
+	checkProxy(transport, "http://node.svc.cluster.local:9100/metrics", "")
+	checkProxy(transport, "http://10.1.2.3:9100/metrics", "")
+	checkProxy(transport, "http://192.168.1.1:9100/metrics", "http://proxy.example.com:3128")
+
+	defer setEnv(map[string]string{
+		"HTTP_PROXY":  "http://env-proxy.example.com:3128",
+		"HTTPS_PROXY": "http://env-tls-proxy.example.com:3128",
+		"NO_PROXY":    "internal.example.com",
+	})()
+	rt, err = NewRoundTripperFromConfig(HTTPClientConfig{ProxyFromEnvironment: true}, "test", false)
+	if err != nil {
+		t.Fatalf("Error creating round tripper: %v", err)
+	}
+	transport, ok = rt.(*http.Transport)
+	if !ok {
+		t.Fatalf("Error casting to http.Transport, %v", rt)
+	}
+	checkProxy(transport, "http://www.example.org/metrics", "http://env-proxy.example.com:3128")
+	checkProxy(transport, "https://www.example.org/metrics", "http://env-tls-proxy.example.com:3128")
+	checkProxy(transport, "http://internal.example.com/metrics", "")
+}
+
+func TestHTTPClientOptions(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("User-Agent"))
+	}))
+	defer testServer.Close()
+
+	var (
+		dials int64
+		calls []string
+	)
+	dialContext := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt64(&dials, 1)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+	middleware := func(name string) func(http.RoundTripper) http.RoundTripper {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				calls = append(calls, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	client, err := NewClientFromConfigWithOptions(HTTPClientConfig{}, "test",
+		WithDialContextFunc(dialContext),
+		WithKeepAlivesDisabled(),
+		WithConntrackDisabled(),
+		WithUserAgent("Douglas Adams mind"),
+		WithRoundTripperMiddleware(middleware("outer"), middleware("inner")),
+	)
+	if err != nil {
+		t.Fatalf("Error creating HTTP Client: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		r, err := client.Get(testServer.URL)
+		if err != nil {
+			t.Fatalf("Can't connect to the test server: %s", err)
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			t.Fatalf("Can't read the server response body: %s", err)
+		}
+		if string(b) != "Douglas Adams mind" {
+			t.Errorf("Expected User-Agent %q, got %q", "Douglas Adams mind", b)
+		}
+	}
+
+	// Keep-alives are disabled so every request dials a new connection.
+	if n := atomic.LoadInt64(&dials); n != 2 {
+		t.Errorf("Expected 2 dials, got %d", n)
+	}
+	if expected := []string{"outer", "inner", "outer", "inner"}; !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected middleware calls %v, got %v", expected, calls)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "unixsocket")
+	if err != nil {
+		t.Fatal("Failed to create tmp dir", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	socket := filepath.Join(tmpDir, "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if bearer := r.Header.Get("Authorization"); bearer != ExpectedBearer {
+			fmt.Fprintf(w, "The expected Bearer Authorization (%s) differs from the obtained Bearer Authorization (%s)", ExpectedBearer, bearer)
+			return
+		}
+		fmt.Fprintf(w, "%s?%s", r.URL.Path, r.URL.RawQuery)
+	})}
+	go testServer.Serve(l)
+	defer testServer.Close()
+
+	proxyURL, err := url.Parse("http://remote.host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := HTTPClientConfig{
+		BearerToken: NewSecretValue(BearerToken),
+		// Unix sockets are never proxied.
+		ProxyURL: URL{proxyURL},
+	}
+
+	var networks []string
+	dialContext := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		networks = append(networks, network)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+	client, err := NewClientFromConfigWithOptions(cfg, "test", WithDialContextFunc(dialContext), WithKeepAlivesDisabled())
+	if err != nil {
+		t.Fatalf("Error creating HTTP Client: %v", err)
+	}
+
+	testCases := []struct {
+		url      string
+		expected string
+	}{
+		{url: "unix://" + socket, expected: "/?"},
+		{url: "unix://" + socket + ":/metrics?format=text", expected: "/metrics?format=text"},
+	}
+	for _, tc := range testCases {
+		r, err := client.Get(tc.url)
+		if err != nil {
+			t.Fatalf("Can't connect to the test server: %s", err)
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			t.Fatalf("Can't read the server response body: %s", err)
+		}
+		if string(b) != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.url, tc.expected, b)
+		}
+	}
+	if expected := []string{"unix", "unix"}; !reflect.DeepEqual(networks, expected) {
+		t.Errorf("Expected dials on %v, got %v", expected, networks)
+	}
+}
+
+func TestUnixSocketRedirect(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "unixsocket")
+	if err != nil {
+		t.Fatal("Failed to create tmp dir", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	remoteServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	}))
+	defer remoteServer.Close()
+
+	socket := filepath.Join(tmpDir, "private.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	socketServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, remoteServer.URL, http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "private")
+	})}
+	go socketServer.Serve(l)
+	defer socketServer.Close()
+
+	client, err := NewClientFromConfig(HTTPClientConfig{}, "test", false)
+	if err != nil {
+		t.Fatalf("Error creating HTTP Client: %v", err)
+	}
+	// Leave an idle connection to the socket in the pool.
+	r, err := client.Get("unix://" + socket)
+	if err != nil {
+		t.Fatalf("Can't connect to the socket: %s", err)
+	}
+	ioutil.ReadAll(r.Body)
+	r.Body.Close()
+
+	for _, u := range []string{
+		remoteServer.URL + "?to=" + url.QueryEscape("http://"+unixSocketHost(socket)+"/"),
+		remoteServer.URL + "?to=" + url.QueryEscape("unix://"+socket),
+		"unix://" + socket + ":/redirect",
+	} {
+		r, err := client.Get(u)
+		if err == nil {
+			b, _ := ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			t.Errorf("%s: expected the redirect to be refused, got %q", u, b)
+		}
+	}
+
+	// The host names standing for unix sockets can't be requested directly.
+	if _, err := client.Get("http://" + unixSocketHost(socket) + "/"); err == nil {
+		t.Errorf("Expected the request to %s to fail", unixSocketHost(socket))
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
 func TestTLSConfig(t *testing.T) {
 	configTLSConfig := TLSConfig{
 		CAFile:             TLSCAChainPath,
@@ -410,11 +950,85 @@ func TestTLSConfig(t *testing.T) {
 
 	// non-nil functions are never equal.
 	tlsConfig.GetClientCertificate = nil
+	// Certificate pools are compared separately since they are not
+	// comparable with reflect.DeepEqual.
+	if !equalCertPools(tlsConfig.RootCAs, expectedTLSConfig.RootCAs) {
+		t.Fatalf("Unexpected RootCAs result: \n\n%+v\n expected\n\n%+v", tlsConfig.RootCAs, expectedTLSConfig.RootCAs)
+	}
+	tlsConfig.RootCAs, expectedTLSConfig.RootCAs = nil, nil
 	if !reflect.DeepEqual(tlsConfig, expectedTLSConfig) {
 		t.Fatalf("Unexpected TLS Config result: \n\n%+v\n expected\n\n%+v", tlsConfig, expectedTLSConfig)
 	}
 }
 
+// equalCertPools returns true if both pools hold certificates with the same
+// subjects, in the same order. x509.CertPool.Equal needs Go 1.19.
+func equalCertPools(a, b *x509.CertPool) bool {
+	return reflect.DeepEqual(a.Subjects(), b.Subjects())
+}
+
+func TestTLSConfigInline(t *testing.T) {
+	bs := getCertificateBlobs(t)
+
+	configTLSConfig := TLSConfig{
+		CA:         string(bs[TLSCAChainPath]),
+		Cert:       string(bs[ClientCertificatePath]),
+		Key:        NewSecretValue(Secret(bs[ClientKeyNoPassPath])),
+		ServerName: "localhost",
+	}
+
+	tlsConfig, err := NewTLSConfig(&configTLSConfig)
+	if err != nil {
+		t.Fatalf("Can't create a new TLS Config from a configuration (%s).", err)
+	}
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AppendCertsFromPEM(bs[TLSCAChainPath])
+	if !equalCertPools(tlsConfig.RootCAs, rootCAs) {
+		t.Fatalf("Unexpected RootCAs result: \n\n%+v\n expected\n\n%+v", tlsConfig.RootCAs, rootCAs)
+	}
+
+	clientCertificate, err := tls.X509KeyPair(bs[ClientCertificatePath], bs[ClientKeyNoPassPath])
+	if err != nil {
+		t.Fatalf("Can't load the client key pair. Reason: %s", err)
+	}
+	cert, err := tlsConfig.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatalf("unexpected error returned by tlsConfig.GetClientCertificate(): %s", err)
+	}
+	if !reflect.DeepEqual(cert, &clientCertificate) {
+		t.Fatalf("Unexpected client certificate result: \n\n%+v\n expected\n\n%+v", cert, clientCertificate)
+	}
+
+	// Inline and file items can be mixed.
+	testServer, err := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ExpectedMessage)
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer testServer.Close()
+
+	for _, cfg := range []TLSConfig{
+		configTLSConfig,
+		{
+			CA:      string(bs[TLSCAChainPath]),
+			Cert:    string(bs[ClientCertificatePath]),
+			KeyFile: ClientKeyNoPassPath,
+		},
+	} {
+		client, err := NewClientFromConfig(HTTPClientConfig{TLSConfig: cfg}, "test", false)
+		if err != nil {
+			t.Fatalf("Error creating HTTP Client: %v", err)
+		}
+		r, err := client.Get(testServer.URL)
+		if err != nil {
+			t.Fatalf("Can't connect to the test server: %s", err)
+		}
+		r.Body.Close()
+	}
+}
+
 func TestTLSConfigEmpty(t *testing.T) {
 	configTLSConfig := TLSConfig{
 		InsecureSkipVerify: true,
@@ -496,11 +1110,11 @@ func TestBasicAuthNoPassword(t *testing.T) {
 	if rt.username != "user" {
 		t.Errorf("Bad HTTP client username: %s", rt.username)
 	}
-	if string(rt.password) != "" {
-		t.Errorf("Expected empty HTTP client password: %s", rt.password)
+	if !rt.password.IsZero() {
+		t.Errorf("Expected empty HTTP client password: %+v", rt.password)
 	}
-	if string(rt.passwordFile) != "" {
-		t.Errorf("Expected empty HTTP client passwordFile: %s", rt.passwordFile)
+	if rt.passwordFile != nil {
+		t.Errorf("Expected empty HTTP client passwordFile: %s", rt.passwordFile.filename)
 	}
 }
 
@@ -522,11 +1136,11 @@ func TestBasicAuthNoUsername(t *testing.T) {
 	if rt.username != "" {
 		t.Errorf("Got unexpected username: %s", rt.username)
 	}
-	if string(rt.password) != "secret" {
-		t.Errorf("Unexpected HTTP client password: %s", string(rt.password))
+	if rt.password != NewSecretValue("secret") {
+		t.Errorf("Unexpected HTTP client password: %+v", rt.password)
 	}
-	if string(rt.passwordFile) != "" {
-		t.Errorf("Expected empty HTTP client passwordFile: %s", rt.passwordFile)
+	if rt.passwordFile != nil {
+		t.Errorf("Expected empty HTTP client passwordFile: %s", rt.passwordFile.filename)
 	}
 }
 
@@ -548,11 +1162,129 @@ func TestBasicAuthPasswordFile(t *testing.T) {
 	if rt.username != "user" {
 		t.Errorf("Bad HTTP client username: %s", rt.username)
 	}
-	if string(rt.password) != "" {
-		t.Errorf("Bad HTTP client password: %s", rt.password)
+	if !rt.password.IsZero() {
+		t.Errorf("Bad HTTP client password: %+v", rt.password)
+	}
+	if rt.passwordFile == nil || rt.passwordFile.filename != "testdata/basic-auth-password" {
+		t.Errorf("Bad HTTP client passwordFile: %v", rt.passwordFile)
+	}
+}
+
+func TestOAuth2(t *testing.T) {
+	var tokenRequests int64
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&tokenRequests, 1)
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("Can't parse the token request form: %s", err)
+		}
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "myclient" || secret != "mysecret" {
+			t.Errorf("Unexpected client credentials %q:%q", id, secret)
+		}
+		if got := r.Form.Get("grant_type"); got != "client_credentials" {
+			t.Errorf("Unexpected grant_type %q", got)
+		}
+		if got := r.Form.Get("scope"); got != "A B" {
+			t.Errorf("Unexpected scope %q", got)
+		}
+		if got := r.Form.Get("hi"); got != "hello" {
+			t.Errorf("Unexpected endpoint parameter hi=%q", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"12345","token_type":"Bearer","expires_in":3600}`)
+	}))
+	defer tokenServer.Close()
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer 12345" {
+			fmt.Fprintf(w, "The expected Authorization (Bearer 12345) differs from the obtained Authorization (%s)", got)
+			return
+		}
+		fmt.Fprint(w, ExpectedMessage)
+	}))
+	defer testServer.Close()
+
+	for _, secretFile := range []bool{false, true} {
+		atomic.StoreInt64(&tokenRequests, 0)
+		cfg, _, err := LoadHTTPConfigFile("testdata/http.conf.oauth2.good.yml")
+		if err != nil {
+			t.Fatalf("Error loading HTTP client config: %v", err)
+		}
+		cfg.OAuth2.TokenURL = tokenServer.URL + "/token"
+		if secretFile {
+			cfg.OAuth2.ClientSecret = SecretValue{}
+			cfg.OAuth2.ClientSecretFile = "testdata/oauth2-client-secret"
+		}
+		client, err := NewClientFromConfig(*cfg, "test", false)
+		if err != nil {
+			t.Fatalf("Error creating HTTP Client: %v", err)
+		}
+
+		for i := 0; i < 3; i++ {
+			r, err := client.Get(testServer.URL)
+			if err != nil {
+				t.Fatalf("Can't connect to the test server: %s", err)
+			}
+			b, err := ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				t.Fatalf("Can't read the server response body: %s", err)
+			}
+			if got := strings.TrimSpace(string(b)); got != ExpectedMessage {
+				t.Fatalf("The expected message %q differs from the obtained message %q", ExpectedMessage, got)
+			}
+		}
+		// The token must be cached between requests.
+		if n := atomic.LoadInt64(&tokenRequests); n != 1 {
+			t.Errorf("Expected 1 token request, got %d", n)
+		}
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	cfg, _, err := LoadHTTPConfigFile("testdata/http.conf.headers.good.yml")
+	if err != nil {
+		t.Fatalf("Error loading HTTP client config: %v", err)
+	}
+	if strings.Contains(cfg.String(), "mysecret") {
+		t.Fatal("http client config's String method reveals header secrets.")
+	}
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected := []string{"plain", "mysecret", "tenant-a"}
+		if got := r.Header["X-Scope-Orgid"]; !reflect.DeepEqual(got, expected) {
+			fmt.Fprintf(w, "The expected X-Scope-OrgID header values (%v) differ from the obtained values (%v)", expected, got)
+			return
+		}
+		fmt.Fprint(w, ExpectedMessage)
+	}))
+	defer testServer.Close()
+
+	client, err := NewClientFromConfig(*cfg, "test", false)
+	if err != nil {
+		t.Fatalf("Error creating HTTP Client: %v", err)
+	}
+	r, err := client.Get(testServer.URL)
+	if err != nil {
+		t.Fatalf("Can't connect to the test server: %s", err)
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		t.Fatalf("Can't read the server response body: %s", err)
+	}
+	if got := strings.TrimSpace(string(b)); got != ExpectedMessage {
+		t.Fatalf("The expected message %q differs from the obtained message %q", ExpectedMessage, got)
+	}
+
+	// Missing header files are reported.
+	cfg.HTTPHeaders["X-Scope-OrgID"] = Header{Files: []string{"missing/headers-file"}}
+	client, err = NewClientFromConfig(*cfg, "test", false)
+	if err != nil {
+		t.Fatalf("Error creating HTTP Client: %v", err)
 	}
-	if string(rt.passwordFile) != "testdata/basic-auth-password" {
-		t.Errorf("Bad HTTP client passwordFile: %s", rt.passwordFile)
+	if _, err = client.Get(testServer.URL); err == nil || !strings.Contains(err.Error(), "unable to read header X-Scope-OrgID file missing/headers-file") {
+		t.Fatalf("Expected error reading the header file, got %v", err)
 	}
 }
 
@@ -615,6 +1347,9 @@ func TestTLSRoundTripper(t *testing.T) {
 		key  string
 
 		errMsg string
+		// reloaded is true if the files are expected to be reloaded.
+		reloaded  bool
+		reloadErr string
 	}{
 		{
 			// Valid certs.
@@ -628,7 +1363,8 @@ func TestTLSRoundTripper(t *testing.T) {
 			cert: ClientCertificatePath,
 			key:  ClientKeyNoPassPath,
 
-			errMsg: "certificate signed by unknown authority",
+			errMsg:   "certificate signed by unknown authority",
+			reloaded: true,
 		},
 		{
 			// Invalid client cert+key.
@@ -636,46 +1372,72 @@ func TestTLSRoundTripper(t *testing.T) {
 			cert: WrongClientCertPath,
 			key:  WrongClientKeyPath,
 
-			errMsg: "remote error: tls",
+			errMsg:   "remote error: tls",
+			reloaded: true,
 		},
 		{
-			// CA file empty
+			// CA file empty, the previous files are kept.
 			ca:   EmptyFile,
+			cert: WrongClientCertPath,
+			key:  WrongClientKeyPath,
+
+			errMsg:    "remote error: tls",
+			reloaded:  true,
+			reloadErr: "unable to use specified CA cert",
+		},
+		{
+			// Valid certs again.
+			ca:   TLSCAChainPath,
 			cert: ClientCertificatePath,
 			key:  ClientKeyNoPassPath,
 
-			errMsg: "unable to use specified CA cert",
+			reloaded: true,
 		},
 		{
-			// cert file empty
+			// cert file empty, the previous files are kept.
 			ca:   TLSCAChainPath,
 			cert: EmptyFile,
 			key:  ClientKeyNoPassPath,
 
-			errMsg: "failed to find any PEM data in certificate input",
+			reloaded:  true,
+			reloadErr: "failed to find any PEM data in certificate input",
 		},
 		{
-			// key file empty
+			// key file empty, the previous files are kept.
 			ca:   TLSCAChainPath,
 			cert: ClientCertificatePath,
 			key:  EmptyFile,
 
-			errMsg: "failed to find any PEM data in key input",
+			reloaded:  true,
+			reloadErr: "failed to find any PEM data in key input",
 		},
 		{
-			// Valid certs again.
+			// cert and key not matching, the previous files are kept.
+			ca:   TLSCAChainPath,
+			cert: ClientCertificatePath,
+			key:  WrongClientKeyPath,
+
+			reloaded:  true,
+			reloadErr: "private key does not match public key",
+		},
+		{
+			// Valid certs again, identical to the ones in use.
 			ca:   TLSCAChainPath,
 			cert: ClientCertificatePath,
 			key:  ClientKeyNoPassPath,
 		},
 	}
 
+	var reloads []error
 	cfg := HTTPClientConfig{
 		TLSConfig: TLSConfig{
 			CAFile:             ca,
 			CertFile:           cert,
 			KeyFile:            key,
-			InsecureSkipVerify: false},
+			InsecureSkipVerify: false,
+			ReloadHook: func(err error) {
+				reloads = append(reloads, err)
+			}},
 	}
 
 	var c *http.Client
@@ -691,12 +1453,25 @@ func TestTLSRoundTripper(t *testing.T) {
 					t.Fatalf("Error creating HTTP Client: %v", err)
 				}
 			}
+			reloads = nil
 
 			req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
 			if err != nil {
 				t.Fatalf("Error creating HTTP request: %v", err)
 			}
 			r, err := c.Do(req)
+
+			switch {
+			case !tc.reloaded && len(reloads) != 0:
+				t.Errorf("Expected no reload, got %v", reloads)
+			case tc.reloaded && len(reloads) != 1:
+				t.Errorf("Expected exactly one reload, got %v", reloads)
+			case tc.reloaded && len(tc.reloadErr) == 0 && reloads[0] != nil:
+				t.Errorf("Expected successful reload, got %q", reloads[0])
+			case tc.reloaded && len(tc.reloadErr) > 0 && (reloads[0] == nil || !strings.Contains(reloads[0].Error(), tc.reloadErr)):
+				t.Errorf("Expected reload error to contain %q, got %v", tc.reloadErr, reloads[0])
+			}
+
 			if len(tc.errMsg) > 0 {
 				if err == nil {
 					r.Body.Close()
@@ -851,6 +1626,117 @@ func TestInvalidHTTPConfigs(t *testing.T) {
 	}
 }
 
+var validHTTPClientConfigFiles = []string{
+	"testdata/http.conf.good.yml",
+	"testdata/http.conf.basic-auth.good.yaml",
+	"testdata/http.conf.authorization.good.yml",
+	"testdata/http.conf.headers.good.yml",
+	"testdata/http.conf.limits.good.yml",
+	"testdata/http.conf.oauth2.good.yml",
+	"testdata/http.conf.proxy.good.yml",
+	"testdata/http.conf.retry.good.yml",
+	"testdata/http.conf.secret-refs.good.yml",
+	"testdata/http.conf.sigv4.good.yml",
+	"testdata/http.conf.transport.good.yml",
+}
+
+func TestHTTPClientConfigJSON(t *testing.T) {
+	for _, filename := range validHTTPClientConfigFiles {
+		expected, content, err := LoadHTTPConfigFile(filename)
+		if err != nil {
+			t.Fatalf("Error loading %s: %s", filename, err)
+		}
+		b, err := yamlToJSON(content)
+		if err != nil {
+			t.Fatalf("Error converting %s to JSON: %s", filename, err)
+		}
+		var got HTTPClientConfig
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Errorf("Error unmarshalling %s as JSON: %s", filename, err)
+			continue
+		}
+		if !reflect.DeepEqual(&got, expected) {
+			t.Errorf("%s: unexpected config from JSON:\n%+v\nexpected\n%+v", filename, got, *expected)
+		}
+
+		b, err = MarshalJSONWithSecrets(expected)
+		if err != nil {
+			t.Fatalf("Error marshalling %s: %s", filename, err)
+		}
+		got = HTTPClientConfig{}
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Errorf("Error unmarshalling marshalled %s: %s", filename, err)
+			continue
+		}
+		if !reflect.DeepEqual(&got, expected) {
+			t.Errorf("%s: unexpected config after JSON round trip:\n%s", filename, b)
+		}
+	}
+}
+
+func TestHideHTTPClientConfigSecretsJSON(t *testing.T) {
+	c, _, err := LoadHTTPConfigFile("testdata/http.conf.good.yml")
+	if err != nil {
+		t.Fatalf("Error parsing %s: %s", "testdata/http.conf.good.yml", err)
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "mysecret") {
+		t.Fatalf("JSON marshalled http client config reveals authentication credentials: %s", b)
+	}
+}
+
+func TestInvalidHTTPConfigsJSON(t *testing.T) {
+	for _, ee := range invalidHTTPClientConfigs {
+		content, err := ioutil.ReadFile(ee.httpClientConfigFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := yamlToJSON(content)
+		if err != nil {
+			t.Fatalf("Error converting %s to JSON: %s", ee.httpClientConfigFile, err)
+		}
+		var cfg HTTPClientConfig
+		err = json.Unmarshal(b, &cfg)
+		if err == nil {
+			t.Errorf("%s: expected error with JSON config but got none", ee.httpClientConfigFile)
+			continue
+		}
+		if !strings.Contains(err.Error(), ee.errMsg) {
+			t.Errorf("%s: expected error to contain %q but got: %s", ee.httpClientConfigFile, ee.errMsg, err)
+		}
+	}
+}
+
+// yamlToJSON converts a YAML document to JSON.
+func yamlToJSON(content []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(content, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonValue(v))
+}
+
+// jsonValue converts the YAML maps held by v to maps which can be marshalled
+// to JSON.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+	}
+	return v
+}
+
 // LoadHTTPConfig parses the YAML input s into a HTTPClientConfig.
 func LoadHTTPConfig(s string) (*HTTPClientConfig, error) {
 	cfg := &HTTPClientConfig{}
@@ -902,3 +1788,33 @@ func NewRoundTripCheckRequest(checkRequest func(*http.Request), theResponse *htt
 			theResponse: theResponse,
 			theError:    theError}}
 }
+
+func TestHTTPClientConfigJSONDurations(t *testing.T) {
+	var cfg HTTPClientConfig
+	err := json.Unmarshal([]byte(`{"idle_conn_timeout":null,"dial_timeout":"30s","tls_handshake_timeout":1000000000}`), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.IdleConnTimeout != 0 {
+		t.Errorf("Expected null idle_conn_timeout to be ignored, got %s", cfg.IdleConnTimeout)
+	}
+	if cfg.DialTimeout != model.Duration(30*time.Second) {
+		t.Errorf("Expected dial_timeout 30s, got %s", cfg.DialTimeout)
+	}
+	if cfg.TLSHandshakeTimeout != model.Duration(time.Second) {
+		t.Errorf("Expected tls_handshake_timeout 1s, got %s", cfg.TLSHandshakeTimeout)
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"dial_timeout":"30s"`) {
+		t.Errorf("Expected dial_timeout to be marshalled as a string, got %s", b)
+	}
+
+	err = json.Unmarshal([]byte(`{"dial_timeout":"30"}`), &cfg)
+	if err == nil || !strings.Contains(err.Error(), "invalid dial_timeout") {
+		t.Errorf("Expected invalid dial_timeout error, got %v", err)
+	}
+}
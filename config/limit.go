@@ -0,0 +1,149 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitConfig configures the rate of the requests sent by a client.
+type RateLimitConfig struct {
+	// The sustained number of requests per second.
+	RequestsPerSecond float64 `yaml:"requests_per_second" json:"requests_per_second"`
+	// The number of requests which can be sent at once above the sustained
+	// rate. Defaults to 1.
+	Burst int `yaml:"burst,omitempty" json:"burst,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *RateLimitConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain RateLimitConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (c *RateLimitConfig) UnmarshalJSON(b []byte) error {
+	return c.UnmarshalYAML(jsonUnmarshal(b))
+}
+
+// Validate validates the RateLimitConfig.
+func (c *RateLimitConfig) Validate() error {
+	if c.RequestsPerSecond <= 0 {
+		return fmt.Errorf("rate_limit requests_per_second must be positive")
+	}
+	if c.Burst < 0 {
+		return fmt.Errorf("rate_limit burst must not be negative")
+	}
+	return nil
+}
+
+// requestLimits holds the state of the limits shared by the round trippers
+// of a client, so that it survives the reloads of the TLS files.
+type requestLimits struct {
+	limiter *rate.Limiter
+	slots   chan struct{}
+}
+
+// newRequestLimits returns the limits for the given rate limit and maximum
+// number of concurrent requests, or nil if there are none.
+func newRequestLimits(rateLimit *RateLimitConfig, maxConcurrentRequests int) *requestLimits {
+	if rateLimit == nil && maxConcurrentRequests <= 0 {
+		return nil
+	}
+	l := &requestLimits{}
+	if rateLimit != nil {
+		l.limiter = rate.NewLimiter(rate.Limit(rateLimit.RequestsPerSecond), orDefault(rateLimit.Burst, 1))
+	}
+	if maxConcurrentRequests > 0 {
+		l.slots = make(chan struct{}, maxConcurrentRequests)
+	}
+	return l
+}
+
+type limitRoundTripper struct {
+	limits *requestLimits
+	rt     http.RoundTripper
+}
+
+// NewLimitRoundTripper delays the requests exceeding the rate limit or the
+// maximum number of concurrent requests, if any. A request is in flight until
+// its response body is closed. Requests whose context is done, or would be
+// done, before they can be sent fail without being sent.
+func NewLimitRoundTripper(rateLimit *RateLimitConfig, maxConcurrentRequests int, rt http.RoundTripper) http.RoundTripper {
+	limits := newRequestLimits(rateLimit, maxConcurrentRequests)
+	if limits == nil {
+		return rt
+	}
+	return &limitRoundTripper{limits: limits, rt: rt}
+}
+
+func (rt *limitRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if rt.limits.slots != nil {
+		select {
+		case rt.limits.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	release := func() {
+		if rt.limits.slots != nil {
+			<-rt.limits.slots
+		}
+	}
+
+	if rt.limits.limiter != nil {
+		if err := rt.limits.limiter.Wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	resp, err := rt.rt.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if rt.limits.slots != nil {
+		resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	}
+	return resp, nil
+}
+
+func (rt *limitRoundTripper) CloseIdleConnections() {
+	if ci, ok := rt.rt.(closeIdler); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+// releasingBody calls release once the body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
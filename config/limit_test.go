@@ -0,0 +1,115 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	var (
+		inFlight, maxInFlight int64
+		unblock               = make(chan struct{})
+	)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		for {
+			m := atomic.LoadInt64(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt64(&maxInFlight, m, n) {
+				break
+			}
+		}
+		<-unblock
+	}))
+	defer testServer.Close()
+
+	cfg, _, err := LoadHTTPConfigFile("testdata/http.conf.limits.good.yml")
+	if err != nil {
+		t.Fatalf("Error loading HTTP client config: %v", err)
+	}
+	client, err := NewClientFromConfig(*cfg, "test", false)
+	if err != nil {
+		t.Fatalf("Error creating HTTP Client: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(testServer.URL)
+			if err != nil {
+				t.Errorf("Can't connect to the test server: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+
+	// Requests waiting for a slot fail once their context is done.
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequest("GET", testServer.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(req.WithContext(ctx)); err == nil {
+		t.Errorf("Expected the request to fail with its context")
+	}
+
+	close(unblock)
+	wg.Wait()
+	if n := atomic.LoadInt64(&maxInFlight); n != 2 {
+		t.Errorf("Expected at most 2 requests in flight, got %d", n)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer testServer.Close()
+
+	rt := NewLimitRoundTripper(&RateLimitConfig{RequestsPerSecond: 20, Burst: 2}, 0, http.DefaultTransport)
+	client := &http.Client{Transport: rt}
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		resp, err := client.Get(testServer.URL)
+		if err != nil {
+			t.Fatalf("Can't connect to the test server: %v", err)
+		}
+		resp.Body.Close()
+	}
+	// The burst of 2 is sent at once, then the requests are spaced by 50ms.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected the requests to be rate limited, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequest("GET", testServer.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(req.WithContext(ctx)); err == nil {
+		t.Errorf("Expected the request to fail with its context")
+	}
+}
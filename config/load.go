@@ -0,0 +1,197 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DirectorySetter is implemented by the configurations holding file paths,
+// which may be relative to the directory of the configuration file.
+type DirectorySetter interface {
+	// SetDirectory joins any relative file paths with dir. Empty and
+	// absolute paths are left unchanged.
+	SetDirectory(dir string)
+}
+
+// loadOptions holds the settings of LoadFile.
+type loadOptions struct {
+	expandEnv bool
+}
+
+// LoadOption defines an option of LoadFile.
+type LoadOption func(options *loadOptions)
+
+// WithEnvExpansion replaces the ${VAR} references in the string values of the
+// configuration file with the values of the environment variables. The values
+// are substituted after the file is parsed, so they can hold any character.
+// A value referenced alone is used as a number or boolean if that is how it
+// is written in YAML. Loading fails if a referenced variable is not set. $VAR
+// references are left untouched, as they are common in password hashes.
+func WithEnvExpansion() LoadOption {
+	return func(opts *loadOptions) {
+		opts.expandEnv = true
+	}
+}
+
+// envRefRegexp matches the ${VAR} references to environment variables.
+var envRefRegexp = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+
+// LoadFile parses the given YAML file into out, which must be a pointer,
+// with yaml.UnmarshalStrict. The relative file paths of the configurations
+// implementing DirectorySetter found in out, such as the ca_file of a
+// TLSConfig or the {file: ...} secret references, are then resolved against
+// the directory of the file.
+func LoadFile(filename string, out interface{}, opts ...LoadOption) error {
+	var o loadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if o.expandEnv {
+		if content, err = expandEnv(content); err != nil {
+			return err
+		}
+	}
+	if err := yaml.UnmarshalStrict(content, out); err != nil {
+		return err
+	}
+	setDirectory(reflect.ValueOf(out), filepath.Dir(filename))
+	return nil
+}
+
+// expandEnv replaces the ${VAR} references in the string values of the YAML
+// document with the values of the environment variables, and returns the
+// document marshalled again. The keys are left untouched.
+func expandEnv(content []byte) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.UnmarshalStrict(content, &doc); err != nil {
+		return nil, err
+	}
+	doc, err := expandEnvValue(doc)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+// expandEnvValue replaces the ${VAR} references in the strings held by v, a
+// value decoded from YAML.
+func expandEnvValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return expandEnvString(v)
+	case map[interface{}]interface{}:
+		for k, e := range v {
+			e, err := expandEnvValue(e)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = e
+		}
+	case []interface{}:
+		for i, e := range v {
+			e, err := expandEnvValue(e)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = e
+		}
+	}
+	return v, nil
+}
+
+// expandEnvString replaces the ${VAR} references in s. If s is a single
+// reference to a value written as a YAML number or boolean, such as 10 or
+// true, that value is returned instead of a string, so that it can be used
+// for numeric and boolean fields.
+func expandEnvString(s string) (interface{}, error) {
+	var err error
+	expanded := envRefRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envRefRegexp.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value
+	})
+	if err != nil {
+		return nil, err
+	}
+	if expanded == s || envRefRegexp.FindString(s) != s {
+		return expanded, nil
+	}
+	var scalar interface{}
+	if yaml.Unmarshal([]byte(expanded), &scalar) != nil {
+		return expanded, nil
+	}
+	switch scalar.(type) {
+	case int, int64, uint64, float64, bool:
+		// Values such as 0123 or yes, which wouldn't be marshalled as
+		// written, are kept as strings.
+		if b, err := yaml.Marshal(scalar); err == nil && strings.TrimSpace(string(b)) == expanded {
+			return scalar, nil
+		}
+	}
+	return expanded, nil
+}
+
+// setDirectory calls SetDirectory on the configurations implementing
+// DirectorySetter held by v in exported fields, pointers, interfaces, maps,
+// slices and arrays. The configurations implementing it are responsible for
+// their own fields.
+func setDirectory(v reflect.Value, dir string) {
+	if v.CanAddr() {
+		if ds, ok := v.Addr().Interface().(DirectorySetter); ok {
+			ds.SetDirectory(dir)
+			return
+		}
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			setDirectory(v.Elem(), dir)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.CanSet() {
+				setDirectory(f, dir)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			setDirectory(v.Index(i), dir)
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			// Map values aren't addressable, update a copy.
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(k))
+			setDirectory(e, dir)
+			v.SetMapIndex(k, e)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type loadTestConfig struct {
+	HTTPClientConfig HTTPClientConfig             `yaml:"http_client"`
+	Clients          map[string]*HTTPClientConfig `yaml:"clients,omitempty"`
+	Scrapes          []struct {
+		TLSConfig TLSConfig `yaml:"tls_config"`
+	} `yaml:"scrapes,omitempty"`
+	URL string `yaml:"url"`
+}
+
+func TestLoadFileRelativePaths(t *testing.T) {
+	var c loadTestConfig
+	if err := LoadFile("testdata/load.relative-paths.good.yml", &c); err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+
+	_, keyRef, _ := c.HTTPClientConfig.TLSConfig.Key.Ref()
+	for _, tc := range []struct {
+		got, expected string
+	}{
+		{c.HTTPClientConfig.BasicAuth.PasswordFile, "testdata/basic-auth-password"},
+		{c.HTTPClientConfig.TLSConfig.CAFile, "testdata/tls-ca-chain.pem"},
+		{c.HTTPClientConfig.TLSConfig.CertFile, "/etc/prometheus/client.crt"},
+		{keyRef, "testdata/secrets/client.key"},
+		{c.HTTPClientConfig.HTTPHeaders["X-Api-Key"].Files[0], "testdata/headers-file"},
+		{c.Clients["oauth2"].OAuth2.ClientSecretFile, "testdata/oauth2-client-secret"},
+		{c.Clients["oauth2"].TLSConfig.KeyFile, "testdata/client-no-pass.key"},
+		{c.Clients["oauth2"].TLSConfig.CertFile, "testdata/client.crt"},
+		{c.Scrapes[0].TLSConfig.CAFile, "ca.crt"},
+		{c.URL, "not/a/file"},
+	} {
+		if tc.got != tc.expected {
+			t.Errorf("Expected %q, got %q", tc.expected, tc.got)
+		}
+	}
+}
+
+func TestLoadFileEnvExpansion(t *testing.T) {
+	defer setEnv(map[string]string{
+		"CONFIG_TEST_BEARER_TOKEN":           "token",
+		"CONFIG_TEST_HOST":                   "example.com",
+		"CONFIG_TEST_MAX_IDLE_CONNS":         "10",
+		"CONFIG_TEST_PROXY_FROM_ENVIRONMENT": "true",
+		"CONFIG_TEST_USERNAME":               "0123",
+		"CONFIG_TEST_PASSWORD":               "p#ss: \"w'rd\"\nurl: http://evil",
+	})()
+
+	var c loadTestConfig
+	if err := LoadFile("testdata/load.env.good.yml", &c, WithEnvExpansion()); err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if c.HTTPClientConfig.BearerToken != NewSecretValue("token") {
+		t.Errorf("Expected bearer token %q, got %+v", "token", c.HTTPClientConfig.BearerToken)
+	}
+	// $VAR references are left untouched.
+	if c.URL != "http://example.com:3128/$2y$10$notexpanded" {
+		t.Errorf("Expected URL %q, got %q", "http://example.com:3128/$2y$10$notexpanded", c.URL)
+	}
+
+	c = loadTestConfig{}
+	if err := LoadFile("testdata/load.env-values.good.yml", &c, WithEnvExpansion()); err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if c.HTTPClientConfig.MaxIdleConns != 10 || !c.HTTPClientConfig.ProxyFromEnvironment {
+		t.Errorf("Expected numbers and booleans to be expanded, got %d and %t", c.HTTPClientConfig.MaxIdleConns, c.HTTPClientConfig.ProxyFromEnvironment)
+	}
+	// The values are used as they are, whatever YAML syntax they hold.
+	if c.HTTPClientConfig.BasicAuth.Username != "0123" {
+		t.Errorf("Expected username %q, got %q", "0123", c.HTTPClientConfig.BasicAuth.Username)
+	}
+	if expected := "p#ss: \"w'rd\"\nurl: http://evil"; c.HTTPClientConfig.BasicAuth.Password != NewSecretValue(Secret(expected)) {
+		t.Errorf("Expected password %q, got %+v", expected, c.HTTPClientConfig.BasicAuth.Password)
+	}
+
+	c = loadTestConfig{}
+	if err := LoadFile("testdata/load.env.good.yml", &c); err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if c.HTTPClientConfig.BearerToken != NewSecretValue("${CONFIG_TEST_BEARER_TOKEN}") {
+		t.Errorf("Expected no expansion without WithEnvExpansion, got %+v", c.HTTPClientConfig.BearerToken)
+	}
+
+	os.Unsetenv("CONFIG_TEST_HOST")
+	err := LoadFile("testdata/load.env.good.yml", &loadTestConfig{}, WithEnvExpansion())
+	if err == nil || err.Error() != "environment variable CONFIG_TEST_HOST is not set" {
+		t.Errorf("Expected error for the unset variable, got %v", err)
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(filename, []byte("http_client:\n  unknown_field: true\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = LoadFile(filename, &loadTestConfig{})
+	if err == nil || !strings.Contains(err.Error(), "field unknown_field not found") {
+		t.Errorf("Expected strict parsing error, got %v", err)
+	}
+
+	err = LoadFile(filepath.Join(dir, "missing.yml"), &loadTestConfig{})
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected missing file error, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("http_client:\n  bearer_token: a\n  bearer_token_file: b\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = LoadFile(filename, &loadTestConfig{})
+	if err == nil || !strings.Contains(err.Error(), "at most one of bearer_token & bearer_token_file must be configured") {
+		t.Errorf("Expected validation error, got %v", err)
+	}
+}
+
+func TestHTTPClientConfigSetDirectory(t *testing.T) {
+	c := HTTPClientConfig{
+		BearerToken:        NewSecretRef("file", "token"),
+		ProxyConnectHeader: map[string][]SecretValue{"X-Auth": {NewSecretRef("file", "/abs/auth"), NewSecretRef("env", "AUTH")}},
+		HTTPHeaders:        map[string]Header{"X-Api-Key": {Secrets: []SecretValue{NewSecretRef("file", "key")}}},
+	}
+	c.SetDirectory("dir")
+
+	expected := HTTPClientConfig{
+		BearerToken:        NewSecretRef("file", filepath.Join("dir", "token")),
+		ProxyConnectHeader: map[string][]SecretValue{"X-Auth": {NewSecretRef("file", "/abs/auth"), NewSecretRef("env", "AUTH")}},
+		HTTPHeaders:        map[string]Header{"X-Api-Key": {Secrets: []SecretValue{NewSecretRef("file", filepath.Join("dir", "key"))}}},
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, c)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"gopkg.in/yaml.v2"
+)
+
+// secretRevealPrefix starts the value of the secrets which are marshalled as
+// is rather than redacted. It is only set in the copies of the values
+// marshalled with MarshalYAMLWithSecrets and MarshalJSONWithSecrets, so that
+// the secrets marshalled concurrently by other callers stay redacted. It
+// can't be configured in a literal secret.
+const secretRevealPrefix = "\x00secret-reveal:"
+
+var (
+	secretType      = reflect.TypeOf(Secret(""))
+	secretValueType = reflect.TypeOf(SecretValue{})
+)
+
+// MarshalYAMLWithSecrets is like yaml.Marshal, except that the secrets held
+// by v are marshalled as is instead of being redacted. It must only be used
+// to write configurations back out, never to display them.
+func MarshalYAMLWithSecrets(v interface{}) ([]byte, error) {
+	if v == nil {
+		return yaml.Marshal(v)
+	}
+	return yaml.Marshal(revealSecrets(reflect.ValueOf(v)).Interface())
+}
+
+// MarshalJSONWithSecrets is like json.Marshal, except that the secrets held
+// by v are marshalled as is instead of being redacted. It must only be used
+// to write configurations back out, never to display them.
+func MarshalJSONWithSecrets(v interface{}) ([]byte, error) {
+	if v == nil {
+		return json.Marshal(v)
+	}
+	return json.Marshal(revealSecrets(reflect.ValueOf(v)).Interface())
+}
+
+// revealSecrets returns a copy of v in which the secrets held in exported
+// fields, pointers, interfaces, maps, slices and arrays are marked to be
+// marshalled as is. References and empty secrets are left untouched. Like
+// the marshallers, it doesn't support cyclic values.
+func revealSecrets(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.String:
+		if v.Type() != secretType {
+			return v
+		}
+		s := v.Interface().(Secret)
+		if s == "" {
+			return v
+		}
+		return reflect.ValueOf(Secret(secretRevealPrefix + string(s)))
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(revealSecrets(v.Elem()))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(revealSecrets(v.Elem()))
+		return c
+	case reflect.Struct:
+		if v.Type() == secretValueType {
+			s := v.Interface().(SecretValue)
+			s.secret = revealSecrets(reflect.ValueOf(s.secret)).Interface().(Secret)
+			return reflect.ValueOf(s)
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < c.NumField(); i++ {
+			if f := c.Field(i); f.CanSet() {
+				f.Set(revealSecrets(v.Field(i)))
+			}
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			c.SetMapIndex(k, revealSecrets(v.MapIndex(k)))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(revealSecrets(v.Index(i)))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(revealSecrets(v.Index(i)))
+		}
+		return c
+	}
+	return v
+}
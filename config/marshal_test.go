@@ -0,0 +1,132 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestSecretJSON(t *testing.T) {
+	testCases := []struct {
+		secret   SecretValue
+		expected string
+	}{
+		{
+			secret:   NewSecretValue("mysecret"),
+			expected: `"\u003csecret\u003e"`,
+		}, {
+			secret:   NewSecretRef("env", "DB_PASS"),
+			expected: `{"env":"DB_PASS"}`,
+		}, {
+			secret:   SecretValue{},
+			expected: `""`,
+		},
+	}
+	for _, tc := range testCases {
+		b, err := json.Marshal(tc.secret)
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %v", tc.secret, err)
+			continue
+		}
+		if string(b) != tc.expected {
+			t.Errorf("%+v: expected %s, got %s", tc.secret, tc.expected, b)
+		}
+	}
+}
+
+func TestMarshalYAMLWithSecrets(t *testing.T) {
+	cfg, _, err := LoadHTTPConfigFile("testdata/http.conf.good.yml")
+	if err != nil {
+		t.Fatalf("Error loading HTTP client config: %v", err)
+	}
+	cfg.ProxyConnectHeader = map[string][]SecretValue{"X-Api-Key": {NewSecretValue("key1"), NewSecretRef("env", "API_KEY")}}
+
+	b, err := MarshalYAMLWithSecrets(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got HTTPClientConfig
+	if err := yaml.UnmarshalStrict(b, &got); err != nil {
+		t.Fatalf("Error unmarshalling:\n%s\n%v", b, err)
+	}
+	if !reflect.DeepEqual(got.BasicAuth, cfg.BasicAuth) {
+		t.Errorf("Expected basic auth %+v, got %+v", cfg.BasicAuth, got.BasicAuth)
+	}
+	if !reflect.DeepEqual(got.ProxyConnectHeader, cfg.ProxyConnectHeader) {
+		t.Errorf("Expected proxy connect header %v, got %v", cfg.ProxyConnectHeader, got.ProxyConnectHeader)
+	}
+
+	// The configuration itself must be left untouched.
+	if cfg.BasicAuth.Password != NewSecretValue("mysecret") {
+		t.Errorf("Expected the password to be left untouched, got %+v", cfg.BasicAuth.Password)
+	}
+	b, err = yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"mysecret", "key1"} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("Expected %q to be redacted, got:\n%s", secret, b)
+		}
+	}
+}
+
+func TestMarshalJSONWithSecrets(t *testing.T) {
+	v := struct {
+		Password Secret            `json:"password"`
+		Token    *Secret           `json:"token"`
+		Ref      SecretValue       `json:"ref"`
+		Headers  map[string]Secret `json:"headers"`
+		Any      interface{}       `json:"any"`
+		secret   Secret
+	}{
+		Password: "mysecret",
+		Token:    new(Secret),
+		Ref:      NewSecretRef("env", "DB_PASS"),
+		Headers:  map[string]Secret{"X-Api-Key": "key"},
+		Any:      []Secret{"other"},
+		secret:   "unexported",
+	}
+	*v.Token = "token"
+
+	b, err := MarshalJSONWithSecrets(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"password":"mysecret","token":"token","ref":{"env":"DB_PASS"},"headers":{"X-Api-Key":"key"},"any":["other"]}`
+	if string(b) != expected {
+		t.Errorf("Expected %s, got %s", expected, b)
+	}
+
+	b, err = json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "mysecret") || strings.Contains(string(b), `:"token"`) {
+		t.Errorf("Expected the secrets to be redacted, got %s", b)
+	}
+}
+
+func TestRevealPrefixIsInvalid(t *testing.T) {
+	var s Secret
+	err := yaml.Unmarshal([]byte("\"\\0secret-reveal:password\""), &s)
+	if err == nil || err.Error() != "invalid secret" {
+		t.Errorf("Expected error %q, got %v", "invalid secret", err)
+	}
+}
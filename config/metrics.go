@@ -0,0 +1,180 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// clientMetrics are the metrics of the requests sent by a client built from
+// an HTTPClientConfig. They carry the name of the client in the client label.
+type clientMetrics struct {
+	requests       *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+	inFlight       prometheus.Gauge
+	dnsDuration    *prometheus.HistogramVec
+	tlsDuration    *prometheus.HistogramVec
+	reloadFailures *prometheus.CounterVec
+}
+
+// newClientMetrics registers the metrics of the named client. Metrics
+// already registered by a client with the same name are reused, so that
+// clients can be recreated when their configuration is reloaded.
+func newClientMetrics(reg prometheus.Registerer, name string) (*clientMetrics, error) {
+	reg = prometheus.WrapRegistererWith(prometheus.Labels{"client": name}, reg)
+	m := &clientMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_client_requests_total",
+			Help: "Total number of HTTP requests sent, by response status code and method.",
+		}, []string{"code", "method"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_client_request_duration_seconds",
+			Help:    "Duration of the HTTP requests until the response headers are received, by response status code and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"code", "method"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_client_in_flight_requests",
+			Help: "Number of HTTP requests waiting for their response headers.",
+		}),
+		dnsDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_client_dns_duration_seconds",
+			Help:    "Time from the start of the HTTP requests to the DNS lookup events.",
+			Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"event"}),
+		tlsDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_client_tls_duration_seconds",
+			Help:    "Time from the start of the HTTP requests to the TLS handshake events.",
+			Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"event"}),
+		reloadFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_client_reload_failures_total",
+			Help: "Total number of failures to reload the TLS files and the credentials files, by source.",
+		}, []string{"source"}),
+	}
+
+	var err error
+	if m.requests, err = registerCounterVec(reg, m.requests); err != nil {
+		return nil, err
+	}
+	if m.duration, err = registerHistogramVec(reg, m.duration); err != nil {
+		return nil, err
+	}
+	inFlight, err := register(reg, m.inFlight)
+	if err != nil {
+		return nil, err
+	}
+	m.inFlight = inFlight.(prometheus.Gauge)
+	if m.dnsDuration, err = registerHistogramVec(reg, m.dnsDuration); err != nil {
+		return nil, err
+	}
+	if m.tlsDuration, err = registerHistogramVec(reg, m.tlsDuration); err != nil {
+		return nil, err
+	}
+	if m.reloadFailures, err = registerCounterVec(reg, m.reloadFailures); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// register registers the collector, or returns the collector already
+// registered in its place.
+func register(reg prometheus.Registerer, c prometheus.Collector) (prometheus.Collector, error) {
+	if err := reg.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func registerCounterVec(reg prometheus.Registerer, c *prometheus.CounterVec) (*prometheus.CounterVec, error) {
+	existing, err := register(reg, c)
+	if err != nil {
+		return nil, err
+	}
+	return existing.(*prometheus.CounterVec), nil
+}
+
+func registerHistogramVec(reg prometheus.Registerer, h *prometheus.HistogramVec) (*prometheus.HistogramVec, error) {
+	existing, err := register(reg, h)
+	if err != nil {
+		return nil, err
+	}
+	return existing.(*prometheus.HistogramVec), nil
+}
+
+// instrumentRoundTripper returns a round tripper updating the request
+// metrics.
+func (m *clientMetrics) instrumentRoundTripper(rt http.RoundTripper) http.RoundTripper {
+	trace := &promhttp.InstrumentTrace{
+		DNSStart: func(t float64) {
+			m.dnsDuration.WithLabelValues("dns_start").Observe(t)
+		},
+		DNSDone: func(t float64) {
+			m.dnsDuration.WithLabelValues("dns_done").Observe(t)
+		},
+		TLSHandshakeStart: func(t float64) {
+			m.tlsDuration.WithLabelValues("tls_handshake_start").Observe(t)
+		},
+		TLSHandshakeDone: func(t float64) {
+			m.tlsDuration.WithLabelValues("tls_handshake_done").Observe(t)
+		},
+	}
+	return &instrumentedRoundTripper{
+		instrumented: promhttp.InstrumentRoundTripperInFlight(m.inFlight,
+			promhttp.InstrumentRoundTripperCounter(m.requests,
+				promhttp.InstrumentRoundTripperTrace(trace,
+					promhttp.InstrumentRoundTripperDuration(m.duration, rt),
+				),
+			),
+		),
+		rt: rt,
+	}
+}
+
+type instrumentedRoundTripper struct {
+	instrumented http.RoundTripper
+	rt           http.RoundTripper
+}
+
+func (rt *instrumentedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return rt.instrumented.RoundTrip(req)
+}
+
+func (rt *instrumentedRoundTripper) CloseIdleConnections() {
+	if ci, ok := rt.rt.(closeIdler); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+// reloadFailed returns a function counting the failures of the given source
+// in addition to calling next, if not nil. It returns next if m is nil.
+func (m *clientMetrics) reloadFailed(source string, next func(error)) func(error) {
+	if m == nil {
+		return next
+	}
+	failures := m.reloadFailures.WithLabelValues(source)
+	return func(err error) {
+		if err != nil {
+			failures.Inc()
+		}
+		if next != nil {
+			next(err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gatherValues returns the values of the counters and gauges and the sample
+// counts of the histograms gathered from reg, by metric name and label
+// values.
+func gatherValues(t *testing.T, reg prometheus.Gatherer) map[string]float64 {
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Error gathering metrics: %v", err)
+	}
+	values := map[string]float64{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			key := mf.GetName()
+			for _, l := range m.GetLabel() {
+				key += "," + l.GetName() + "=" + l.GetValue()
+			}
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				values[key] = m.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				values[key] = m.GetGauge().GetValue()
+			case dto.MetricType_HISTOGRAM:
+				values[key] = float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return values
+}
+
+func TestClientMetrics(t *testing.T) {
+	testServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer testServer.Close()
+
+	reg := prometheus.NewRegistry()
+	cfg := HTTPClientConfig{
+		TLSConfig: TLSConfig{InsecureSkipVerify: true},
+	}
+	client, err := NewClientFromConfigWithOptions(cfg, "test", WithRegisterer(reg))
+	if err != nil {
+		t.Fatalf("Error creating HTTP Client: %v", err)
+	}
+	for _, path := range []string{"/", "/", "/missing"} {
+		resp, err := client.Get(testServer.URL + path)
+		if err != nil {
+			t.Fatalf("Can't connect to the test server: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	// A client with the same name reuses the registered metrics.
+	if _, err := NewClientFromConfigWithOptions(cfg, "test", WithRegisterer(reg)); err != nil {
+		t.Fatalf("Error creating HTTP Client with the same name: %v", err)
+	}
+
+	values := gatherValues(t, reg)
+	for key, expected := range map[string]float64{
+		"http_client_requests_total,client=test,code=200,method=get":            2,
+		"http_client_requests_total,client=test,code=404,method=get":            1,
+		"http_client_request_duration_seconds,client=test,code=200,method=get":  2,
+		"http_client_in_flight_requests,client=test":                            0,
+		"http_client_tls_duration_seconds,client=test,event=tls_handshake_done": 1,
+	} {
+		if got, ok := values[key]; !ok || got != expected {
+			t.Errorf("Expected %s to be %v, got %v (found: %t)", key, expected, got, ok)
+		}
+	}
+}
+
+func TestClientMetricsReloadFailures(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer testServer.Close()
+
+	reg := prometheus.NewRegistry()
+	var hookErrs []error
+	cfg := HTTPClientConfig{
+		BearerTokenFile: filepath.Join("testdata", "missing-bearer-token"),
+	}
+	client, err := NewClientFromConfigWithOptions(cfg, "test", WithRegisterer(reg))
+	if err != nil {
+		t.Fatalf("Error creating HTTP Client: %v", err)
+	}
+	if _, err := client.Get(testServer.URL); err == nil {
+		t.Errorf("Expected an error reading the bearer token file")
+	}
+
+	// Failing to read the CA file calls the reload hook too.
+	tmpDir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	caFile := filepath.Join(tmpDir, "ca.pem")
+	b, err := ioutil.ReadFile(TLSCAChainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(caFile, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg = HTTPClientConfig{
+		TLSConfig: TLSConfig{
+			CAFile:     caFile,
+			ReloadHook: func(err error) { hookErrs = append(hookErrs, err) },
+		},
+	}
+	client, err = NewClientFromConfigWithOptions(cfg, "test", WithRegisterer(reg))
+	if err != nil {
+		t.Fatalf("Error creating HTTP Client: %v", err)
+	}
+	if err := os.Remove(caFile); err != nil {
+		t.Fatal(err)
+	}
+	client.Get(testServer.URL)
+
+	values := gatherValues(t, reg)
+	for key, expected := range map[string]float64{
+		"http_client_reload_failures_total,client=test,source=credentials_file": 1,
+		"http_client_reload_failures_total,client=test,source=tls":              1,
+	} {
+		if got, ok := values[key]; !ok || got != expected {
+			t.Errorf("Expected %s to be %v, got %v (found: %t)", key, expected, got, ok)
+		}
+	}
+	if len(hookErrs) != 1 || hookErrs[0] == nil {
+		t.Errorf("Expected the reload hook to be called with an error, got %v", hookErrs)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+)
+
+// SPKIPin returns the pin of the certificate for the pinned_spki_sha256
+// setting of TLSConfig: the base64 encoded SHA-256 hash of its subject
+// public key info.
+func SPKIPin(cert *x509.Certificate) string {
+	h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// decodeSPKIPin decodes a pin of the pinned_spki_sha256 setting.
+func decodeSPKIPin(pin string) ([sha256.Size]byte, error) {
+	var h [sha256.Size]byte
+	b, err := base64.StdEncoding.DecodeString(pin)
+	if err != nil || len(b) != sha256.Size {
+		return h, fmt.Errorf("invalid pinned_spki_sha256 %q: must be a base64 encoded SHA-256 hash", pin)
+	}
+	copy(h[:], b)
+	return h, nil
+}
+
+// verifyPeerCertificate returns the function checking the certificates of
+// the peer against the pins before calling the VerifyPeerCertificate hook,
+// for the VerifyPeerCertificate field of tls.Config. The configuration must
+// be valid.
+func (c *TLSConfig) verifyPeerCertificate() func([][]byte, [][]*x509.Certificate) error {
+	pins := make(map[[sha256.Size]byte]struct{}, len(c.PinnedSPKISHA256))
+	for _, pin := range c.PinnedSPKISHA256 {
+		h, _ := decodeSPKIPin(pin)
+		pins[h] = struct{}{}
+	}
+	hook := c.VerifyPeerCertificate
+
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(pins) > 0 {
+			if err := verifyPins(pins, rawCerts, verifiedChains); err != nil {
+				return err
+			}
+		}
+		if hook != nil {
+			return hook(rawCerts, verifiedChains)
+		}
+		return nil
+	}
+}
+
+// verifyPins checks that a certificate of the verified chains matches one of
+// the pins. When the chains aren't verified, only the leaf certificate, whose
+// key is proven by the handshake, is checked: the other certificates could
+// be sent by anyone.
+func verifyPins(pins map[[sha256.Size]byte]struct{}, rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	pinned := func(cert *x509.Certificate) bool {
+		_, ok := pins[sha256.Sum256(cert.RawSubjectPublicKeyInfo)]
+		return ok
+	}
+	if len(verifiedChains) == 0 {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("no certificate to check against the pinned_spki_sha256 pins")
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		if pinned(cert) {
+			return nil
+		}
+	}
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			if pinned(cert) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no certificate matches the pinned_spki_sha256 pins")
+}
@@ -0,0 +1,184 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// readPin returns the pin of the first certificate of the PEM file.
+func readPin(t *testing.T, filename string) string {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		t.Fatalf("No certificate in %s", filename)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return SPKIPin(cert)
+}
+
+func TestSPKIPinning(t *testing.T) {
+	testServer, err := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ExpectedMessage)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testServer.Close()
+
+	var (
+		serverPin = readPin(t, ServerCertificatePath)
+		// The CA issuing the server certificate.
+		caPin     = readPin(t, TLSCAChainPath)
+		clientPin = readPin(t, ClientCertificatePath)
+	)
+
+	testCases := []struct {
+		insecure bool
+		ca       string
+		pins     []string
+		errMsg   string
+	}{
+		{
+			// Self-signed certificates are verified with the pin of the leaf.
+			insecure: true,
+			pins:     []string{clientPin, serverPin},
+		}, {
+			// Unverified chains are only checked against their leaf.
+			insecure: true,
+			pins:     []string{caPin},
+			errMsg:   "no certificate matches the pinned_spki_sha256 pins",
+		}, {
+			ca:   TLSCAChainPath,
+			pins: []string{caPin},
+		}, {
+			ca:     TLSCAChainPath,
+			pins:   []string{clientPin},
+			errMsg: "no certificate matches the pinned_spki_sha256 pins",
+		},
+	}
+	for i, tc := range testCases {
+		cfg := HTTPClientConfig{
+			TLSConfig: TLSConfig{
+				CAFile:             tc.ca,
+				CertFile:           ClientCertificatePath,
+				KeyFile:            ClientKeyNoPassPath,
+				InsecureSkipVerify: tc.insecure,
+				PinnedSPKISHA256:   tc.pins,
+			},
+		}
+		client, err := NewClientFromConfig(cfg, "test", false)
+		if err != nil {
+			t.Fatalf("%d: error creating HTTP client: %v", i, err)
+		}
+		resp, err := client.Get(testServer.URL)
+		if tc.errMsg != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+				t.Errorf("%d: expected error containing %q, got %v", i, tc.errMsg, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: unexpected error: %v", i, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestVerifyPeerCertificateAfterReload(t *testing.T) {
+	testServer, err := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ExpectedMessage)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testServer.Close()
+
+	tmpDir, err := ioutil.TempDir("", "pinning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	ca, err := ioutil.ReadFile(TLSCAChainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(tmpDir, "ca")
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var verified, reloaded int32
+	cfg := HTTPClientConfig{
+		TLSConfig: TLSConfig{
+			CAFile:           caFile,
+			CertFile:         ClientCertificatePath,
+			KeyFile:          ClientKeyNoPassPath,
+			PinnedSPKISHA256: []string{readPin(t, TLSCAChainPath)},
+			VerifyPeerCertificate: func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+				if len(verifiedChains) == 0 {
+					return fmt.Errorf("unverified chain")
+				}
+				atomic.AddInt32(&verified, 1)
+				return nil
+			},
+			ReloadHook: func(err error) {
+				if err != nil {
+					t.Errorf("Unexpected reload error: %v", err)
+				}
+				atomic.AddInt32(&reloaded, 1)
+			},
+		},
+	}
+	client, err := NewClientFromConfig(cfg, "test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() {
+		resp, err := client.Get(testServer.URL)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	}
+	get()
+	if err := ioutil.WriteFile(caFile, append(ca, '\n'), 0600); err != nil {
+		t.Fatal(err)
+	}
+	get()
+
+	if n := atomic.LoadInt32(&reloaded); n != 1 {
+		t.Errorf("Expected the CA to be reloaded once, got %d", n)
+	}
+	if n := atomic.LoadInt32(&verified); n != 2 {
+		t.Errorf("Expected the hook to be called for both handshakes, got %d", n)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// errIncorrectKeyPassword is returned when the client key can't be decrypted
+// with the configured password.
+var errIncorrectKeyPassword = errors.New("incorrect key password")
+
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+)
+
+// pbkdf2PRFs maps the OIDs of the pseudorandom functions of PBKDF2 to their
+// hash functions.
+var pbkdf2PRFs = map[string]func() hash.Hash{
+	"1.2.840.113549.2.7":  sha1.New, // hmacWithSHA1, the default.
+	"1.2.840.113549.2.8":  sha256.New224,
+	"1.2.840.113549.2.9":  sha256.New,
+	"1.2.840.113549.2.10": sha512.New384,
+	"1.2.840.113549.2.11": sha512.New,
+}
+
+// pbes2Cipher is a CBC encryption scheme of PBES2.
+type pbes2Cipher struct {
+	keySize   int
+	newCipher func(key []byte) (cipher.Block, error)
+}
+
+// pbes2Ciphers maps the OIDs of the PBES2 encryption schemes to their ciphers.
+var pbes2Ciphers = map[string]pbes2Cipher{
+	"2.16.840.1.101.3.4.1.2":  {16, aes.NewCipher},          // aes128-CBC
+	"2.16.840.1.101.3.4.1.22": {24, aes.NewCipher},          // aes192-CBC
+	"2.16.840.1.101.3.4.1.42": {32, aes.NewCipher},          // aes256-CBC
+	"1.2.840.113549.3.7":      {24, des.NewTripleDESCipher}, // des-ede3-cbc
+}
+
+// encryptedPrivateKeyInfo is the EncryptedPrivateKeyInfo structure of
+// RFC 5208.
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params is the PBES2-params structure of RFC 8018.
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params is the PBKDF2-params structure of RFC 8018. Only the salts
+// given as octet strings are supported.
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPrivateKey decrypts the encrypted private keys of the PEM data with
+// the password, and returns the PEM data with the decrypted keys. Both the
+// PKCS #8 keys encrypted with PBES2 ("ENCRYPTED PRIVATE KEY" blocks) and the
+// legacy keys encrypted as described by RFC 1423 (blocks with a
+// "Proc-Type: 4,ENCRYPTED" header) are supported. The PEM data is returned
+// unchanged if it doesn't hold any encrypted key, with or without password.
+func decryptPrivateKey(keyPEM, password []byte) ([]byte, error) {
+	var (
+		out       bytes.Buffer
+		rest      = keyPEM
+		encrypted bool
+	)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		switch {
+		case block.Type == "ENCRYPTED PRIVATE KEY":
+			if password == nil {
+				return nil, errors.New("the key is encrypted but no key password is configured")
+			}
+			der, err := decryptPKCS8(block.Bytes, password)
+			if err != nil {
+				return nil, err
+			}
+			block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+			encrypted = true
+		case x509.IsEncryptedPEMBlock(block):
+			if password == nil {
+				return nil, errors.New("the key is encrypted but no key password is configured")
+			}
+			der, err := x509.DecryptPEMBlock(block, password)
+			if err == x509.IncorrectPasswordError {
+				return nil, errIncorrectKeyPassword
+			}
+			if err != nil {
+				return nil, err
+			}
+			block = &pem.Block{Type: block.Type, Bytes: der}
+			encrypted = true
+		}
+		if err := pem.Encode(&out, block); err != nil {
+			return nil, err
+		}
+	}
+	if !encrypted {
+		return keyPEM, nil
+	}
+	return out.Bytes(), nil
+}
+
+// decryptPKCS8 decrypts the DER encoded EncryptedPrivateKeyInfo with the
+// password and returns the DER encoded PKCS #8 private key. Only PBES2 with
+// PBKDF2 and a CBC encryption scheme is supported, which is what OpenSSL
+// uses by default.
+func decryptPKCS8(der, password []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if err := unmarshalASN1(der, &info); err != nil {
+		return nil, fmt.Errorf("malformed encrypted private key: %s", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported private key encryption algorithm %s, only PBES2 is supported", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if err := unmarshalASN1(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("malformed PBES2 parameters: %s", err)
+	}
+
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported PBES2 key derivation function %s, only PBKDF2 is supported", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if err := unmarshalASN1(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("malformed PBKDF2 parameters: %s", err)
+	}
+	prf := sha1.New
+	if len(kdf.PRF.Algorithm) > 0 {
+		var ok bool
+		if prf, ok = pbkdf2PRFs[kdf.PRF.Algorithm.String()]; !ok {
+			return nil, fmt.Errorf("unsupported PBKDF2 pseudorandom function %s", kdf.PRF.Algorithm)
+		}
+	}
+
+	c, ok := pbes2Ciphers[params.EncryptionScheme.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported PBES2 encryption scheme %s", params.EncryptionScheme.Algorithm)
+	}
+	if kdf.KeyLength != 0 && kdf.KeyLength != c.keySize {
+		return nil, fmt.Errorf("invalid PBKDF2 key length %d for the encryption scheme %s", kdf.KeyLength, params.EncryptionScheme.Algorithm)
+	}
+	var iv []byte
+	if err := unmarshalASN1(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("malformed PBES2 encryption scheme parameters: %s", err)
+	}
+
+	block, err := c.newCipher(pbkdf2.Key(password, kdf.Salt, kdf.IterationCount, c.keySize, prf))
+	if err != nil {
+		return nil, err
+	}
+	bs := block.BlockSize()
+	if len(iv) != bs {
+		return nil, fmt.Errorf("invalid IV length %d for the encryption scheme %s", len(iv), params.EncryptionScheme.Algorithm)
+	}
+	data := info.EncryptedData
+	if len(data) == 0 || len(data)%bs != 0 {
+		return nil, errors.New("malformed encrypted private key: invalid data length")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	// Remove the PKCS #7 padding. A wrong password most likely yields an
+	// invalid padding, otherwise an invalid key.
+	n := int(plain[len(plain)-1])
+	if n == 0 || n > bs {
+		return nil, errIncorrectKeyPassword
+	}
+	for _, b := range plain[len(plain)-n:] {
+		if int(b) != n {
+			return nil, errIncorrectKeyPassword
+		}
+	}
+	plain = plain[:len(plain)-n]
+	if _, err := x509.ParsePKCS8PrivateKey(plain); err != nil {
+		return nil, errIncorrectKeyPassword
+	}
+	return plain, nil
+}
+
+// unmarshalASN1 parses the DER encoded value of b into v, which must use all
+// of b.
+func unmarshalASN1(b []byte, v interface{}) error {
+	rest, err := asn1.Unmarshal(b, v)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return errors.New("trailing data")
+	}
+	return nil
+}
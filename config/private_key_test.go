@@ -0,0 +1,240 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// clientKeyPassword is the password of the encrypted client keys.
+const clientKeyPassword = "prometheus-key-password"
+
+func TestDecryptPrivateKey(t *testing.T) {
+	expected, err := tls.LoadX509KeyPair(ClientCertificatePath, ClientKeyNoPassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := ioutil.ReadFile(ClientCertificatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		filename string
+		password []byte
+		errMsg   string
+	}{
+		{
+			filename: ClientKeyNoPassPath,
+		}, {
+			filename: ClientKeyNoPassPath,
+			password: []byte(clientKeyPassword),
+		}, {
+			filename: ClientKeyPKCS8AES256Path,
+			password: []byte(clientKeyPassword),
+		}, {
+			// The PBKDF2 pseudorandom function defaults to hmacWithSHA1.
+			filename: ClientKeyPKCS8DES3Path,
+			password: []byte(clientKeyPassword),
+		}, {
+			filename: ClientKeyLegacyAES128Path,
+			password: []byte(clientKeyPassword),
+		}, {
+			filename: ClientKeyPKCS8AES256Path,
+			errMsg:   "the key is encrypted but no key password is configured",
+		}, {
+			filename: ClientKeyLegacyAES128Path,
+			errMsg:   "the key is encrypted but no key password is configured",
+		}, {
+			filename: ClientKeyPKCS8AES256Path,
+			password: []byte("wrong"),
+			errMsg:   "incorrect key password",
+		}, {
+			filename: ClientKeyPKCS8DES3Path,
+			password: []byte("wrong"),
+			errMsg:   "incorrect key password",
+		}, {
+			filename: ClientKeyLegacyAES128Path,
+			password: []byte("wrong"),
+			errMsg:   "incorrect key password",
+		}, {
+			filename: ClientKeyPKCS8PBES1Path,
+			password: []byte(clientKeyPassword),
+			errMsg:   "unsupported private key encryption algorithm 1.2.840.113549.1.12.1.3, only PBES2 is supported",
+		},
+	}
+	for _, tc := range testCases {
+		keyPEM, err := ioutil.ReadFile(tc.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decryptPrivateKey(keyPEM, tc.password)
+		if tc.errMsg != "" {
+			if err == nil || err.Error() != tc.errMsg {
+				t.Errorf("%s: expected error %q, got %v", tc.filename, tc.errMsg, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.filename, err)
+			continue
+		}
+		pair, err := tls.X509KeyPair(certPEM, got)
+		if err != nil {
+			t.Errorf("%s: unable to use the decrypted key: %v", tc.filename, err)
+			continue
+		}
+		if !reflect.DeepEqual(pair.PrivateKey, expected.PrivateKey) {
+			t.Errorf("%s: the decrypted key doesn't match %s", tc.filename, ClientKeyNoPassPath)
+		}
+	}
+}
+
+func TestTLSConfigEncryptedKey(t *testing.T) {
+	testServer, err := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ExpectedMessage)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testServer.Close()
+
+	testCases := []struct {
+		keyFile         string
+		keyPassword     SecretValue
+		keyPasswordFile string
+		errMsg          string
+	}{
+		{
+			keyFile:         ClientKeyPKCS8AES256Path,
+			keyPasswordFile: ClientKeyPasswordPath,
+		}, {
+			keyFile:     ClientKeyLegacyAES128Path,
+			keyPassword: NewSecretValue(clientKeyPassword),
+		}, {
+			keyFile: ClientKeyPKCS8AES256Path,
+			errMsg:  "unable to decrypt client key testdata/client-pkcs8-aes256.key: the key is encrypted but no key password is configured",
+		}, {
+			keyFile:     ClientKeyPKCS8AES256Path,
+			keyPassword: NewSecretValue("wrong"),
+			errMsg:      "unable to decrypt client key testdata/client-pkcs8-aes256.key: incorrect key password",
+		}, {
+			keyFile:         ClientKeyPKCS8AES256Path,
+			keyPasswordFile: MissingKey,
+			errMsg:          "unable to load specified client key password " + MissingKey,
+		},
+	}
+	for i, tc := range testCases {
+		cfg := HTTPClientConfig{
+			TLSConfig: TLSConfig{
+				CAFile:          TLSCAChainPath,
+				CertFile:        ClientCertificatePath,
+				KeyFile:         tc.keyFile,
+				KeyPassword:     tc.keyPassword,
+				KeyPasswordFile: tc.keyPasswordFile,
+			},
+		}
+		client, err := NewClientFromConfig(cfg, "test", false)
+		if tc.errMsg != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+				t.Errorf("%d: expected error containing %q, got %v", i, tc.errMsg, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: error creating HTTP client: %v", i, err)
+			continue
+		}
+		resp, err := client.Get(testServer.URL)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %v", i, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestTLSConfigInlineEncryptedKey(t *testing.T) {
+	key, err := ioutil.ReadFile(ClientKeyPKCS8AES256Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ioutil.ReadFile(ClientCertificatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := NewTLSConfig(&TLSConfig{
+		Cert:        string(cert),
+		Key:         NewSecretValue(Secret(key)),
+		KeyPassword: NewSecretValue(clientKeyPassword),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := tlsConfig.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := tlsConfig.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The inline key is decrypted once, not on every handshake.
+	if first != second {
+		t.Errorf("Expected the same client certificate for every handshake")
+	}
+}
+
+func BenchmarkTLSRoundTripperEncryptedKey(b *testing.B) {
+	testServer, err := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ExpectedMessage)
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer testServer.Close()
+
+	for _, keyFile := range []string{ClientKeyNoPassPath, ClientKeyPKCS8AES256Path} {
+		b.Run(filepath.Base(keyFile), func(b *testing.B) {
+			cfg := HTTPClientConfig{
+				TLSConfig: TLSConfig{
+					CAFile:      TLSCAChainPath,
+					CertFile:    ClientCertificatePath,
+					KeyFile:     keyFile,
+					KeyPassword: NewSecretValue(clientKeyPassword),
+				},
+			}
+			client, err := NewClientFromConfig(cfg, "test", false)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := client.Get(testServer.URL)
+				if err != nil {
+					b.Fatal(err)
+				}
+				ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// DefaultRetryConfig is the default retry configuration.
+var DefaultRetryConfig = RetryConfig{
+	MaxAttempts:    3,
+	StatusCodes:    []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+	IdempotentOnly: true,
+	MinBackoff:     model.Duration(100 * time.Millisecond),
+	MaxBackoff:     model.Duration(10 * time.Second),
+}
+
+// RetryConfig configures the retries of the failed requests.
+type RetryConfig struct {
+	// The maximum number of attempts, including the first one.
+	MaxAttempts int `yaml:"max_attempts" json:"max_attempts"`
+	// The response status codes which are retried. Requests failing before
+	// getting a response are always retried.
+	StatusCodes []int `yaml:"status_codes,omitempty" json:"status_codes,omitempty"`
+	// Only retry the requests with idempotent methods.
+	IdempotentOnly bool `yaml:"idempotent_only" json:"idempotent_only"`
+	// The backoff before the first retry. It doubles for every subsequent
+	// retry, with jitter.
+	MinBackoff model.Duration `yaml:"min_backoff,omitempty" json:"min_backoff,omitempty"`
+	// The maximum backoff between attempts. Responses asking with Retry-After
+	// to wait longer are not retried.
+	MaxBackoff model.Duration `yaml:"max_backoff,omitempty" json:"max_backoff,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *RetryConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultRetryConfig
+	// Don't let the JSON decoder, which reuses the slices, overwrite the
+	// default status codes.
+	c.StatusCodes = append([]int(nil), DefaultRetryConfig.StatusCodes...)
+	type plain RetryConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. The backoffs are
+// parsed as in YAML.
+func (c *RetryConfig) UnmarshalJSON(b []byte) error {
+	return c.UnmarshalYAML(jsonUnmarshalWithDurations(b, map[string]*model.Duration{
+		"min_backoff": &c.MinBackoff,
+		"max_backoff": &c.MaxBackoff,
+	}))
+}
+
+// MarshalJSON implements the json.Marshaler interface. The backoffs are
+// encoded as in YAML, such as "100ms".
+func (c RetryConfig) MarshalJSON() ([]byte, error) {
+	type plain RetryConfig
+	return jsonMarshalWithDurations(plain(c), map[string]model.Duration{
+		"min_backoff": c.MinBackoff,
+		"max_backoff": c.MaxBackoff,
+	})
+}
+
+// Validate validates the RetryConfig.
+func (c *RetryConfig) Validate() error {
+	if c.MaxAttempts < 0 {
+		return fmt.Errorf("retry max_attempts must not be negative")
+	}
+	for _, code := range c.StatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid retry status code %d", code)
+		}
+	}
+	if c.MinBackoff < 0 || c.MaxBackoff < 0 {
+		return fmt.Errorf("retry min_backoff & max_backoff must not be negative")
+	}
+	if c.MaxBackoff != 0 && c.MinBackoff > c.MaxBackoff {
+		return fmt.Errorf("retry min_backoff %s must not be greater than max_backoff %s", c.MinBackoff, c.MaxBackoff)
+	}
+	return nil
+}
+
+// idempotentMethods are the HTTP methods whose requests can be sent more than
+// once.
+var idempotentMethods = map[string]struct{}{
+	"":        {},
+	"GET":     {},
+	"HEAD":    {},
+	"OPTIONS": {},
+	"TRACE":   {},
+	"PUT":     {},
+	"DELETE":  {},
+}
+
+type retryRoundTripper struct {
+	maxAttempts    int
+	statusCodes    map[int]struct{}
+	idempotentOnly bool
+	minBackoff     time.Duration
+	maxBackoff     time.Duration
+	rt             http.RoundTripper
+	// wait blocks for d or until ctx is done.
+	wait func(ctx context.Context, d time.Duration) error
+}
+
+// NewRetryRoundTripper retries the requests failing with a retryable status
+// code or before getting a response, with an exponential backoff. A
+// Retry-After header in the response overrides the backoff. The request
+// body is rewound with GetBody, so requests with a body but no GetBody are
+// never retried. A zero max attempts, backoff or empty status codes list falls
+// back to DefaultRetryConfig.
+func NewRetryRoundTripper(cfg *RetryConfig, rt http.RoundTripper) http.RoundTripper {
+	statusCodes := cfg.StatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = DefaultRetryConfig.StatusCodes
+	}
+	codes := make(map[int]struct{}, len(statusCodes))
+	for _, code := range statusCodes {
+		codes[code] = struct{}{}
+	}
+	return &retryRoundTripper{
+		maxAttempts:    orDefault(cfg.MaxAttempts, DefaultRetryConfig.MaxAttempts),
+		statusCodes:    codes,
+		idempotentOnly: cfg.IdempotentOnly,
+		minBackoff:     durationOrDefault(cfg.MinBackoff, time.Duration(DefaultRetryConfig.MinBackoff)),
+		maxBackoff:     durationOrDefault(cfg.MaxBackoff, time.Duration(DefaultRetryConfig.MaxBackoff)),
+		rt:             rt,
+		wait:           waitContext,
+	}
+}
+
+func (rt *retryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !rt.retryable(req) {
+		return rt.rt.RoundTrip(req)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = cloneRequest(req)
+			req.Body = body
+		}
+
+		resp, err := rt.rt.RoundTrip(req)
+		if attempt >= rt.maxAttempts {
+			return resp, err
+		}
+		if err == nil {
+			if _, ok := rt.statusCodes[resp.StatusCode]; !ok {
+				return resp, nil
+			}
+		} else if req.Context().Err() != nil {
+			return nil, err
+		}
+
+		backoff := rt.backoff(attempt)
+		if resp != nil {
+			if d, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				if d > rt.maxBackoff {
+					return resp, nil
+				}
+				backoff = d
+			}
+			// Drain the body so that the connection can be reused.
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err := rt.wait(req.Context(), backoff); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryable returns true if the request can be sent more than once.
+func (rt *retryRoundTripper) retryable(req *http.Request) bool {
+	if rt.maxAttempts <= 1 {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if _, ok := idempotentMethods[req.Method]; !ok && rt.idempotentOnly {
+		return false
+	}
+	return true
+}
+
+// backoff returns the duration to wait after the given attempt: the minimum
+// backoff doubled for every previous retry and capped to the maximum backoff,
+// of which a random half is subtracted.
+func (rt *retryRoundTripper) backoff(attempt int) time.Duration {
+	d := rt.minBackoff
+	for i := 1; i < attempt && d < rt.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > rt.maxBackoff {
+		d = rt.maxBackoff
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+func (rt *retryRoundTripper) CloseIdleConnections() {
+	if ci, ok := rt.rt.(closeIdler); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+// retryAfter parses the value of a Retry-After header, either a number of
+// seconds or an HTTP date.
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// waitContext blocks for d or until ctx is done.
+func waitContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
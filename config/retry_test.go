@@ -0,0 +1,246 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// newTestRetryRoundTripper returns a retry round tripper recording its
+// backoffs instead of waiting.
+func newTestRetryRoundTripper(cfg *RetryConfig, waits *[]time.Duration) *retryRoundTripper {
+	rt := NewRetryRoundTripper(cfg, http.DefaultTransport).(*retryRoundTripper)
+	rt.wait = func(ctx context.Context, d time.Duration) error {
+		*waits = append(*waits, d)
+		return ctx.Err()
+	}
+	return rt
+}
+
+func TestRetryRoundTripper(t *testing.T) {
+	var (
+		attempts int64
+		bodies   []string
+	)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		switch atomic.AddInt64(&attempts, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(ExpectedMessage))
+		}
+	}))
+	defer testServer.Close()
+
+	cfg, _, err := LoadHTTPConfigFile("testdata/http.conf.retry.good.yml")
+	if err != nil {
+		t.Fatalf("Error loading HTTP client config: %v", err)
+	}
+	if cfg.Retry.MaxAttempts != 3 || !cfg.Retry.IdempotentOnly || cfg.Retry.MaxBackoff != DefaultRetryConfig.MaxBackoff {
+		t.Errorf("Expected retry defaults to apply, got %+v", cfg.Retry)
+	}
+
+	configured, err := NewRoundTripperFromConfig(*cfg, "test", false)
+	if err != nil {
+		t.Fatalf("Error creating round tripper: %v", err)
+	}
+	if _, ok := configured.(*retryRoundTripper); !ok {
+		t.Errorf("Expected the outermost round tripper to retry, got %T", configured)
+	}
+
+	var waits []time.Duration
+	rt := newTestRetryRoundTripper(cfg.Retry, &waits)
+	req, err := http.NewRequest("PUT", testServer.URL, bytes.NewReader([]byte("payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200, got %d", resp.StatusCode)
+	}
+
+	// The body is rewound for every attempt.
+	if expected := []string{"payload", "payload", "payload"}; !reflect.DeepEqual(bodies, expected) {
+		t.Errorf("Expected bodies %v, got %v", expected, bodies)
+	}
+	if len(waits) != 2 {
+		t.Fatalf("Expected 2 backoffs, got %v", waits)
+	}
+	if waits[0] < 50*time.Millisecond || waits[0] >= 100*time.Millisecond {
+		t.Errorf("Expected a first backoff between 50ms and 100ms, got %s", waits[0])
+	}
+	if waits[1] != 2*time.Second {
+		t.Errorf("Expected the Retry-After backoff of 2s, got %s", waits[1])
+	}
+}
+
+func TestRetryRoundTripperNotRetried(t *testing.T) {
+	var attempts int64
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&attempts, 1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer testServer.Close()
+
+	testCases := []struct {
+		name     string
+		cfg      RetryConfig
+		method   string
+		attempts int64
+	}{
+		{
+			name:     "non-idempotent method",
+			cfg:      RetryConfig{IdempotentOnly: true},
+			method:   "POST",
+			attempts: 1,
+		}, {
+			name:     "Retry-After longer than max_backoff",
+			cfg:      RetryConfig{MaxBackoff: model.Duration(30 * time.Second)},
+			method:   "GET",
+			attempts: 1,
+		}, {
+			name:     "non-idempotent method allowed",
+			cfg:      RetryConfig{MaxAttempts: 2, MaxBackoff: model.Duration(2 * time.Minute)},
+			method:   "POST",
+			attempts: 2,
+		},
+	}
+	for _, tc := range testCases {
+		atomic.StoreInt64(&attempts, 0)
+		var waits []time.Duration
+		rt := newTestRetryRoundTripper(&tc.cfg, &waits)
+		req, err := http.NewRequest(tc.method, testServer.URL, strings.NewReader("payload"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected status code 503, got %d", tc.name, resp.StatusCode)
+		}
+		if n := atomic.LoadInt64(&attempts); n != tc.attempts {
+			t.Errorf("%s: expected %d attempts, got %d", tc.name, tc.attempts, n)
+		}
+	}
+
+	// Bodies which can't be rewound are sent once.
+	atomic.StoreInt64(&attempts, 0)
+	var waits []time.Duration
+	rt := newTestRetryRoundTripper(&RetryConfig{MaxBackoff: model.Duration(2 * time.Minute)}, &waits)
+	req, err := http.NewRequest("PUT", testServer.URL, ioutil.NopCloser(strings.NewReader("payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if n := atomic.LoadInt64(&attempts); n != 1 {
+		t.Errorf("Expected 1 attempt, got %d", n)
+	}
+}
+
+func TestRetryRoundTripperContext(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer testServer.Close()
+
+	rt := NewRetryRoundTripper(&RetryConfig{MaxAttempts: 5, MinBackoff: model.Duration(time.Minute)}, http.DefaultTransport)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequest("GET", testServer.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err = rt.RoundTrip(req.WithContext(ctx))
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("Expected the backoff to be interrupted by the context")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{value: ""},
+		{value: "invalid"},
+		{value: "-1"},
+		{value: "120", expected: 2 * time.Minute, ok: true},
+		{value: "Mon, 01 Jun 2020 12:00:30 GMT", expected: 30 * time.Second, ok: true},
+		{value: "Mon, 01 Jun 2020 11:00:00 GMT", expected: 0, ok: true},
+	}
+	for _, tc := range testCases {
+		d, ok := retryAfter(tc.value, now)
+		if d != tc.expected || ok != tc.ok {
+			t.Errorf("%q: expected (%s, %t), got (%s, %t)", tc.value, tc.expected, tc.ok, d, ok)
+		}
+	}
+}
+
+func TestRetryConfigJSON(t *testing.T) {
+	defaultStatusCodes := append([]int(nil), DefaultRetryConfig.StatusCodes...)
+
+	var cfg RetryConfig
+	if err := json.Unmarshal([]byte(`{"status_codes":[500],"max_backoff":"1m"}`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	expected := DefaultRetryConfig
+	expected.StatusCodes = []int{500}
+	expected.MaxBackoff = model.Duration(time.Minute)
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, cfg)
+	}
+	if !reflect.DeepEqual(DefaultRetryConfig.StatusCodes, defaultStatusCodes) {
+		t.Errorf("Expected the default status codes to be left untouched, got %v", DefaultRetryConfig.StatusCodes)
+	}
+
+	err := json.Unmarshal([]byte(`{"min_backoff":"1m","max_backoff":"1s"}`), &cfg)
+	if err == nil || err.Error() != "retry min_backoff 1m must not be greater than max_backoff 1s" {
+		t.Errorf("Expected validation error, got %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretProvider fetches the secrets referenced with its scheme, such as
+// DB_PASS for the reference {env: DB_PASS}.
+type SecretProvider interface {
+	// FetchSecret returns the secret referenced by ref.
+	FetchSecret(ctx context.Context, ref string) (string, error)
+}
+
+// SecretProviderFunc adapts a function to the SecretProvider interface.
+type SecretProviderFunc func(ctx context.Context, ref string) (string, error)
+
+// FetchSecret implements the SecretProvider interface.
+func (f SecretProviderFunc) FetchSecret(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+// registeredSecretProvider is a provider and the time its secrets are cached.
+type registeredSecretProvider struct {
+	provider SecretProvider
+	refresh  time.Duration
+}
+
+// cachedSecret is a secret fetched from a provider.
+type cachedSecret struct {
+	value   string
+	fetched time.Time
+}
+
+var (
+	secretProvidersMtx sync.RWMutex
+	secretProviders    = map[string]registeredSecretProvider{}
+
+	secretCacheMtx sync.Mutex
+	secretCache    = map[string]cachedSecret{}
+
+	// fileSecrets caches the files of the file provider by name.
+	fileSecretsMtx sync.Mutex
+	fileSecrets    = map[string]*credentialsFile{}
+)
+
+func init() {
+	RegisterSecretProvider("env", SecretProviderFunc(fetchEnvSecret), 0)
+	RegisterSecretProvider("file", SecretProviderFunc(fetchFileSecret), 0)
+}
+
+// RegisterSecretProvider registers the provider of the secrets referenced
+// with the given scheme, replacing the provider previously registered for
+// it, if any. The fetched secrets are cached for the refresh duration; they
+// are fetched again every time they are used if it is zero.
+//
+// The providers are registered for the whole process and must be registered
+// before the configurations referencing them are loaded.
+func RegisterSecretProvider(scheme string, provider SecretProvider, refresh time.Duration) {
+	secretProvidersMtx.Lock()
+	defer secretProvidersMtx.Unlock()
+	secretProviders[scheme] = registeredSecretProvider{provider: provider, refresh: refresh}
+
+	// Drop the secrets cached from the previous provider.
+	secretCacheMtx.Lock()
+	defer secretCacheMtx.Unlock()
+	for key := range secretCache {
+		if strings.HasPrefix(key, scheme+":") {
+			delete(secretCache, key)
+		}
+	}
+}
+
+// getSecretProvider returns the provider registered for the scheme.
+func getSecretProvider(scheme string) (registeredSecretProvider, bool) {
+	secretProvidersMtx.RLock()
+	defer secretProvidersMtx.RUnlock()
+	p, ok := secretProviders[scheme]
+	return p, ok
+}
+
+// SecretValue is a secret which is either configured inline, as a Secret, or
+// references a secret of a SecretProvider, such as {env: DB_PASS} or
+// {file: /etc/secrets/password} in YAML. Unlike a Secret, it can't be
+// converted to a string: use Resolve to get the value of the secret.
+type SecretValue struct {
+	secret Secret
+	// scheme and ref are set if the value is a reference.
+	scheme string
+	ref    string
+}
+
+// NewSecretValue returns a SecretValue holding the given secret.
+func NewSecretValue(s Secret) SecretValue {
+	return SecretValue{secret: s}
+}
+
+// NewSecretRef returns a SecretValue referencing a secret of the provider
+// registered for the scheme, equivalent to {scheme: ref} in YAML.
+func NewSecretRef(scheme, ref string) SecretValue {
+	return SecretValue{scheme: scheme, ref: ref}
+}
+
+// IsZero returns true if no secret is configured.
+func (v SecretValue) IsZero() bool {
+	return v == SecretValue{}
+}
+
+// Ref returns the scheme and the reference of the secret, and whether the
+// secret is a reference.
+func (v SecretValue) Ref() (scheme, ref string, ok bool) {
+	return v.scheme, v.ref, v.scheme != ""
+}
+
+// Resolve returns the value of the secret, fetching it from its provider if
+// the secret is a reference.
+func (v SecretValue) Resolve(ctx context.Context) (string, error) {
+	if v.scheme == "" {
+		return string(v.secret), nil
+	}
+	p, ok := getSecretProvider(v.scheme)
+	if !ok {
+		return "", fmt.Errorf("unknown secret provider %q", v.scheme)
+	}
+
+	key := v.scheme + ":" + v.ref
+	if p.refresh > 0 {
+		secretCacheMtx.Lock()
+		cached, ok := secretCache[key]
+		secretCacheMtx.Unlock()
+		if ok && time.Since(cached.fetched) < p.refresh {
+			return cached.value, nil
+		}
+	}
+
+	value, err := p.provider.FetchSecret(ctx, v.ref)
+	if err != nil {
+		return "", fmt.Errorf("unable to fetch secret %s from provider %q: %s", v.ref, v.scheme, err)
+	}
+	if p.refresh > 0 {
+		secretCacheMtx.Lock()
+		secretCache[key] = cachedSecret{value: value, fetched: time.Now()}
+		secretCacheMtx.Unlock()
+	}
+	return value, nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface for SecretValues.
+// References are marshalled as is since they don't hold the secrets, other
+// secrets as Secrets.
+func (v SecretValue) MarshalYAML() (interface{}, error) {
+	if v.scheme != "" {
+		return map[string]string{v.scheme: v.ref}, nil
+	}
+	return v.secret.MarshalYAML()
+}
+
+// MarshalJSON implements the json.Marshaler interface for SecretValues, like
+// MarshalYAML.
+func (v SecretValue) MarshalJSON() ([]byte, error) {
+	if v.scheme != "" {
+		return json.Marshal(map[string]string{v.scheme: v.ref})
+	}
+	return v.secret.MarshalJSON()
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for SecretValues.
+func (v *SecretValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var ref map[string]string
+	if err := unmarshal(&ref); err == nil {
+		if len(ref) != 1 {
+			return fmt.Errorf("secret reference must have exactly one provider, got %d", len(ref))
+		}
+		for scheme, r := range ref {
+			if _, ok := getSecretProvider(scheme); !ok {
+				return fmt.Errorf("unknown secret provider %q", scheme)
+			}
+			*v = NewSecretRef(scheme, r)
+		}
+		return nil
+	}
+
+	var s Secret
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	*v = NewSecretValue(s)
+	return nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for SecretValues,
+// like UnmarshalYAML.
+func (v *SecretValue) UnmarshalJSON(b []byte) error {
+	if isJSONNull(b) {
+		return nil
+	}
+	return v.UnmarshalYAML(jsonUnmarshal(b))
+}
+
+// SetDirectory joins the path of a file reference with dir if it is
+// relative. Other secrets are left unchanged.
+func (v *SecretValue) SetDirectory(dir string) {
+	if v.scheme == "file" {
+		v.ref = JoinDir(dir, v.ref)
+	}
+}
+
+// omitEmptySecret returns nil if no secret is configured and v otherwise, so
+// that the MarshalJSON methods can omit the empty SecretValues as
+// encoding/json omits the empty strings.
+func omitEmptySecret(v SecretValue) *SecretValue {
+	if v.IsZero() {
+		return nil
+	}
+	return &v
+}
+
+// fetchEnvSecret returns the value of the environment variable.
+func fetchEnvSecret(_ context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// fetchFileSecret returns the content of the file, without the leading and
+// trailing white space. As the other credentials files, the file is read
+// again only when it changes, and the last content read successfully is used
+// if it can't be read.
+func fetchFileSecret(_ context.Context, filename string) (string, error) {
+	fileSecretsMtx.Lock()
+	f, ok := fileSecrets[filename]
+	if !ok {
+		f = credentialsFileOptions{}.newCredentialsFile(filename, "secret file")
+		fileSecrets[filename] = f
+	}
+	fileSecretsMtx.Unlock()
+	return f.read()
+}
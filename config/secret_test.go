@@ -0,0 +1,237 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestSecretYAML(t *testing.T) {
+	testCases := []struct {
+		yaml     string
+		expected SecretValue
+		errMsg   string
+		marshal  string
+	}{
+		{
+			yaml:     `mysecret`,
+			expected: NewSecretValue("mysecret"),
+			marshal:  "<secret>\n",
+		}, {
+			yaml:     `{env: DB_PASS}`,
+			expected: NewSecretRef("env", "DB_PASS"),
+			marshal:  "env: DB_PASS\n",
+		}, {
+			yaml:     `{file: /etc/secrets/password}`,
+			expected: NewSecretRef("file", "/etc/secrets/password"),
+			marshal:  "file: /etc/secrets/password\n",
+		}, {
+			yaml:   `{env: DB_PASS, file: /etc/secrets/password}`,
+			errMsg: "secret reference must have exactly one provider, got 2",
+		}, {
+			yaml:   `{vault: secret/db}`,
+			errMsg: `unknown secret provider "vault"`,
+		}, {
+			yaml:   "\"\\0secret-reveal:password\"",
+			errMsg: "invalid secret",
+		},
+	}
+	for _, tc := range testCases {
+		var s SecretValue
+		err := yaml.Unmarshal([]byte(tc.yaml), &s)
+		if tc.errMsg != "" {
+			if err == nil || err.Error() != tc.errMsg {
+				t.Errorf("%s: expected error %q, got %v", tc.yaml, tc.errMsg, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.yaml, err)
+			continue
+		}
+		if s != tc.expected {
+			t.Errorf("%s: expected %+v, got %+v", tc.yaml, tc.expected, s)
+		}
+		b, err := yaml.Marshal(s)
+		if err != nil {
+			t.Errorf("%s: unexpected error marshalling: %v", tc.yaml, err)
+			continue
+		}
+		if string(b) != tc.marshal {
+			t.Errorf("%s: expected marshalled %q, got %q", tc.yaml, tc.marshal, b)
+		}
+	}
+}
+
+func TestSecretRejectsRefs(t *testing.T) {
+	// The callers converting a Secret to a string would use the reference
+	// as the secret.
+	var s Secret
+	err := yaml.Unmarshal([]byte(`{env: DB_PASS}`), &s)
+	if err == nil || err.Error() != "secret references are not supported for this secret" {
+		t.Errorf("Expected error for the secret reference, got %v", err)
+	}
+}
+
+func TestSecretResolve(t *testing.T) {
+	defer setEnv(map[string]string{"CONFIG_TEST_SECRET": "from env"})()
+
+	testCases := []struct {
+		secret   SecretValue
+		expected string
+		errMsg   string
+	}{
+		{secret: NewSecretValue("inline"), expected: "inline"},
+		{secret: NewSecretRef("env", "CONFIG_TEST_SECRET"), expected: "from env"},
+		{secret: NewSecretRef("file", "testdata/headers-file"), expected: "tenant-a"},
+		{secret: NewSecretRef("env", "CONFIG_TEST_UNSET_SECRET"), errMsg: `unable to fetch secret CONFIG_TEST_UNSET_SECRET from provider "env": environment variable CONFIG_TEST_UNSET_SECRET is not set`},
+		{secret: NewSecretRef("unknown", "ref"), errMsg: `unknown secret provider "unknown"`},
+	}
+	for _, tc := range testCases {
+		got, err := tc.secret.Resolve(context.Background())
+		if tc.errMsg != "" {
+			if err == nil || err.Error() != tc.errMsg {
+				t.Errorf("%+v: expected error %q, got %v", tc.secret, tc.errMsg, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %v", tc.secret, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("%+v: expected %q, got %q", tc.secret, tc.expected, got)
+		}
+	}
+}
+
+func TestFileSecretProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "password")
+	s := NewSecretRef("file", filename)
+	for _, tc := range []struct {
+		content  string
+		remove   bool
+		expected string
+	}{
+		{content: "password1\n", expected: "password1"},
+		// The changes of the file are picked up.
+		{content: "password-2\n", expected: "password-2"},
+		// The last content is kept if the file can't be read anymore.
+		{remove: true, expected: "password-2"},
+	} {
+		if tc.remove {
+			if err := os.Remove(filename); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := ioutil.WriteFile(filename, []byte(tc.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.Resolve(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got != tc.expected {
+			t.Errorf("Expected %q, got %q", tc.expected, got)
+		}
+	}
+}
+
+func TestRegisterSecretProvider(t *testing.T) {
+	var fetches int
+	RegisterSecretProvider("test", SecretProviderFunc(func(ctx context.Context, ref string) (string, error) {
+		fetches++
+		return fmt.Sprintf("%s-%d", ref, fetches), nil
+	}), 50*time.Millisecond)
+
+	s := NewSecretRef("test", "token")
+	for i := 0; i < 3; i++ {
+		got, err := s.Resolve(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got != "token-1" {
+			t.Errorf("Expected the cached secret %q, got %q", "token-1", got)
+		}
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	got, err := s.Resolve(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got != "token-2" {
+		t.Errorf("Expected the refreshed secret %q, got %q", "token-2", got)
+	}
+}
+
+func TestSecretRefsInHTTPClientConfig(t *testing.T) {
+	defer setEnv(map[string]string{"CONFIG_TEST_BASIC_AUTH_PASSWORD": "password1"})()
+
+	var username, password, apiKey string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ = r.BasicAuth()
+		apiKey = r.Header.Get("X-Api-Key")
+	}))
+	defer testServer.Close()
+
+	cfg, _, err := LoadHTTPConfigFile("testdata/http.conf.secret-refs.good.yml")
+	if err != nil {
+		t.Fatalf("Error loading HTTP client config: %v", err)
+	}
+	client, err := NewClientFromConfig(*cfg, "test", false)
+	if err != nil {
+		t.Fatalf("Error creating HTTP Client: %v", err)
+	}
+
+	// The secrets are resolved for every request.
+	for _, expected := range []string{"password1", "password2"} {
+		setEnv(map[string]string{"CONFIG_TEST_BASIC_AUTH_PASSWORD": expected})
+		resp, err := client.Get(testServer.URL)
+		if err != nil {
+			t.Fatalf("Can't connect to the test server: %v", err)
+		}
+		resp.Body.Close()
+		if username != "user" || password != expected {
+			t.Errorf("Expected basic auth user:%s, got %s:%s", expected, username, password)
+		}
+		if apiKey != "tenant-a" {
+			t.Errorf("Expected X-Api-Key header %q, got %q", "tenant-a", apiKey)
+		}
+	}
+
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "env: CONFIG_TEST_BASIC_AUTH_PASSWORD") {
+		t.Errorf("Expected the secret reference to be marshalled, got:\n%s", b)
+	}
+}
@@ -0,0 +1,600 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SigV4 configures the signing of requests with AWS Signature Version 4.
+//
+// If no access key is configured, the credentials are read from the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment
+// variables or from the profile of the shared credentials file. If a role ARN
+// is configured, these credentials are used to assume the role and the
+// requests are signed with the temporary credentials of the role.
+type SigV4 struct {
+	// The AWS region. Defaults to the AWS_REGION and AWS_DEFAULT_REGION
+	// environment variables and to the region of the profile.
+	Region string `yaml:"region,omitempty" json:"region,omitempty"`
+	// The AWS access key ID.
+	AccessKey string `yaml:"access_key,omitempty" json:"access_key,omitempty"`
+	// The AWS secret access key.
+	SecretKey SecretValue `yaml:"secret_key,omitempty" json:"secret_key,omitempty"`
+	// The profile of the shared credentials and config files. Defaults to the
+	// AWS_PROFILE environment variable, then to "default".
+	Profile string `yaml:"profile,omitempty" json:"profile,omitempty"`
+	// The ARN of the role to assume.
+	RoleARN string `yaml:"role_arn,omitempty" json:"role_arn,omitempty"`
+	// The AWS service the requests are signed for. Defaults to "aps", the
+	// managed Prometheus service.
+	Service string `yaml:"service,omitempty" json:"service,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *SigV4) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain SigV4
+	return unmarshal((*plain)(c))
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (c SigV4) MarshalJSON() ([]byte, error) {
+	type plain SigV4
+	return json.Marshal(struct {
+		plain
+		SecretKey *SecretValue `json:"secret_key,omitempty"`
+	}{plain(c), omitEmptySecret(c.SecretKey)})
+}
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	sigV4DateFormat = "20060102"
+	// sigV4DefaultService is the service of the managed Prometheus
+	// remote-write and query endpoints.
+	sigV4DefaultService = "aps"
+	// awsCredentialsExpiryWindow is how long before their expiration the
+	// temporary credentials of an assumed role are refreshed.
+	awsCredentialsExpiryWindow = 5 * time.Minute
+)
+
+// sigV4IgnoredHeaders are the headers which are not signed because they may
+// be altered on the way to the server.
+var sigV4IgnoredHeaders = map[string]struct{}{
+	"authorization":   {},
+	"user-agent":      {},
+	"x-amzn-trace-id": {},
+	"expect":          {},
+}
+
+// awsCredentials are the credentials used to sign a request.
+type awsCredentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	// expiration is zero for long-term credentials.
+	expiration time.Time
+}
+
+// sigV4Signer signs requests for a service in a region.
+type sigV4Signer struct {
+	region  string
+	service string
+}
+
+// sign adds the X-Amz-Date, X-Amz-Security-Token and Authorization headers
+// to the request. The request must not be sent concurrently as its body, if
+// any, is read to compute its hash.
+func (s *sigV4Signer) sign(req *http.Request, creds awsCredentials, now time.Time) error {
+	payloadHash, err := sigV4PayloadHash(req)
+	if err != nil {
+		return err
+	}
+
+	now = now.UTC()
+	req.Header.Set("X-Amz-Date", now.Format(sigV4TimeFormat))
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	canonicalHeaders, signedHeaders := sigV4CanonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigV4CanonicalURI(req.URL),
+		sigV4CanonicalQuery(req.URL),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{now.Format(sigV4DateFormat), s.region, s.service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		now.Format(sigV4TimeFormat),
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretKey), now.Format(sigV4DateFormat))
+	for _, part := range []string{s.region, s.service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, creds.accessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+// sigV4PayloadHash returns the hex encoded SHA-256 hash of the request body.
+// If the body cannot be obtained again with GetBody, it is buffered and
+// replaced.
+func sigV4PayloadHash(req *http.Request) (string, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return hexSHA256(nil), nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		h := sha256.New()
+		if _, err := bufio.NewReader(body).WriteTo(h); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	b, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+	return hexSHA256(b), nil
+}
+
+// sigV4CanonicalURI returns the normalized and URI-encoded request path.
+func sigV4CanonicalURI(u *url.URL) string {
+	p := u.Path
+	if p == "" {
+		return "/"
+	}
+	clean := path.Clean(p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	segments := strings.Split(clean, "/")
+	for i, s := range segments {
+		segments[i] = sigV4Escape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// sigV4CanonicalQuery returns the URI-encoded query parameters, sorted by
+// name and value.
+func sigV4CanonicalQuery(u *url.URL) string {
+	if u.RawQuery == "" {
+		return ""
+	}
+	var params [][2]string
+	for name, values := range u.Query() {
+		for _, v := range values {
+			params = append(params, [2]string{sigV4Escape(name), sigV4Escape(v)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	pairs := make([]string, len(params))
+	for i, p := range params {
+		pairs[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(pairs, "&")
+}
+
+// sigV4CanonicalHeaders returns the canonical headers of the request, each
+// followed by a newline, and the semicolon-separated list of their names.
+func sigV4CanonicalHeaders(req *http.Request) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	if strings.HasSuffix(host, ":80") && req.URL.Scheme == "http" {
+		host = strings.TrimSuffix(host, ":80")
+	} else if strings.HasSuffix(host, ":443") && req.URL.Scheme == "https" {
+		host = strings.TrimSuffix(host, ":443")
+	}
+
+	headers := map[string]string{"host": host}
+	names := []string{"host"}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if _, ok := sigV4IgnoredHeaders[name]; ok || name == "host" {
+			continue
+		}
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[name] = strings.Join(trimmed, ",")
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	for _, name := range names {
+		b.WriteString(name + ":" + headers[name] + "\n")
+	}
+	return b.String(), strings.Join(names, ";")
+}
+
+// sigV4Escape URI-encodes every byte of s except the unreserved characters.
+func sigV4Escape(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func hexSHA256(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+type sigV4RoundTripper struct {
+	config *SigV4
+	signer *sigV4Signer
+	next   http.RoundTripper
+	// stsEndpoint is the URL of the AWS Security Token Service.
+	stsEndpoint string
+	now         func() time.Time
+
+	sharedCreds *awsSharedCredentials
+
+	mtx       sync.Mutex
+	roleCreds awsCredentials
+}
+
+// NewSigV4RoundTripper signs a request with AWS Signature Version 4 for the
+// configured service, the managed Prometheus service by default. The
+// credentials are resolved for every request, except the temporary
+// credentials of the configured role which are cached until they are about
+// to expire, and the shared credentials file which is parsed again only when
+// it changes. The requests to assume the role are sent through next, so they
+// share its TLS and proxy settings.
+func NewSigV4RoundTripper(cfg *SigV4, next http.RoundTripper) (http.RoundTripper, error) {
+	return newSigV4RoundTripper(cfg, next, credentialsFileOptions{})
+}
+
+func newSigV4RoundTripper(cfg *SigV4, next http.RoundTripper, files credentialsFileOptions) (http.RoundTripper, error) {
+	region := cfg.Region
+	if region == "" {
+		region = awsDefaultRegion(cfg.Profile)
+	}
+	if region == "" {
+		return nil, fmt.Errorf("sigv4 region must be configured or found in the environment")
+	}
+	service := cfg.Service
+	if service == "" {
+		service = sigV4DefaultService
+	}
+	return &sigV4RoundTripper{
+		config:      cfg,
+		signer:      &sigV4Signer{region: region, service: service},
+		next:        next,
+		stsEndpoint: fmt.Sprintf("https://sts.%s.amazonaws.com/", region),
+		now:         time.Now,
+		sharedCreds: &awsSharedCredentials{files: files},
+	}, nil
+}
+
+func (rt *sigV4RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	creds, err := rt.credentials(req)
+	if err != nil {
+		return nil, err
+	}
+	req = cloneRequest(req)
+	if err := rt.signer.sign(req, creds, rt.now()); err != nil {
+		return nil, fmt.Errorf("unable to sign request with sigv4: %s", err)
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// credentials returns the credentials used to sign the requests.
+func (rt *sigV4RoundTripper) credentials(req *http.Request) (awsCredentials, error) {
+	if rt.config.RoleARN == "" {
+		return rt.baseCredentials(req.Context())
+	}
+
+	rt.mtx.Lock()
+	defer rt.mtx.Unlock()
+	if rt.roleCreds.accessKey != "" && rt.now().Add(awsCredentialsExpiryWindow).Before(rt.roleCreds.expiration) {
+		return rt.roleCreds, nil
+	}
+	base, err := rt.baseCredentials(req.Context())
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	creds, err := rt.assumeRole(req, base)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("unable to assume role %s: %s", rt.config.RoleARN, err)
+	}
+	rt.roleCreds = creds
+	return creds, nil
+}
+
+// baseCredentials returns the configured credentials, or those found in the
+// environment or in the shared credentials file.
+func (rt *sigV4RoundTripper) baseCredentials(ctx context.Context) (awsCredentials, error) {
+	if rt.config.AccessKey != "" {
+		secretKey, err := rt.config.SecretKey.Resolve(ctx)
+		if err != nil {
+			return awsCredentials{}, fmt.Errorf("unable to resolve sigv4 secret key: %s", err)
+		}
+		return awsCredentials{accessKey: rt.config.AccessKey, secretKey: secretKey}, nil
+	}
+	if rt.config.Profile == "" {
+		accessKey := firstEnv("AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY")
+		secretKey := firstEnv("AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY")
+		if accessKey != "" && secretKey != "" {
+			return awsCredentials{accessKey: accessKey, secretKey: secretKey, sessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+		}
+	}
+
+	filename := awsSharedFilename("AWS_SHARED_CREDENTIALS_FILE", "credentials")
+	return rt.sharedCreds.get(filename, awsProfile(rt.config.Profile))
+}
+
+// awsSharedCredentials caches the credentials of a profile of the shared
+// credentials file. The file is cached as the other credentials files and
+// parsed again only when its content changes.
+type awsSharedCredentials struct {
+	files credentialsFileOptions
+
+	mtx     sync.Mutex
+	file    *credentialsFile
+	parsed  bool
+	content string
+	profile string
+	creds   awsCredentials
+	err     error
+}
+
+// get returns the credentials of the profile found in the file.
+func (c *awsSharedCredentials) get(filename, profile string) (awsCredentials, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.file == nil || c.file.filename != filename {
+		c.file = c.files.newCredentialsFile(filename, "AWS shared credentials file")
+		c.parsed = false
+	}
+	content, err := c.file.read()
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if c.parsed && content == c.content && profile == c.profile {
+		return c.creds, c.err
+	}
+	c.creds, c.err = parseAWSCredentials(content, filename, profile)
+	c.parsed, c.content, c.profile = true, content, profile
+	return c.creds, c.err
+}
+
+// parseAWSCredentials returns the credentials of the profile found in the
+// content of the shared credentials file.
+func parseAWSCredentials(content, filename, profile string) (awsCredentials, error) {
+	section, err := parseINISection(strings.NewReader(content), profile)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("unable to read AWS shared credentials file %s: %s", filename, err)
+	}
+	creds := awsCredentials{
+		accessKey:    section["aws_access_key_id"],
+		secretKey:    section["aws_secret_access_key"],
+		sessionToken: section["aws_session_token"],
+	}
+	if creds.accessKey == "" || creds.secretKey == "" {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials found for profile %q in %s", profile, filename)
+	}
+	return creds, nil
+}
+
+// assumeRole returns the temporary credentials of the configured role.
+func (rt *sigV4RoundTripper) assumeRole(orig *http.Request, base awsCredentials) (awsCredentials, error) {
+	form := url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {"2011-06-15"},
+		"RoleArn":         {rt.config.RoleARN},
+		"RoleSessionName": {fmt.Sprintf("prometheus-%d", rt.now().UnixNano())},
+	}
+	req, err := http.NewRequest("POST", rt.stsEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req = req.WithContext(orig.Context())
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signer := &sigV4Signer{region: rt.signer.region, service: "sts"}
+	if err := signer.sign(req, base, rt.now()); err != nil {
+		return awsCredentials{}, err
+	}
+
+	resp, err := rt.next.RoundTrip(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("unexpected status code %d from %s: %s", resp.StatusCode, rt.stsEndpoint, bytes.TrimSpace(b))
+	}
+
+	var result struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleResult>Credentials"`
+	}
+	if err := xml.Unmarshal(b, &result); err != nil {
+		return awsCredentials{}, err
+	}
+	return awsCredentials{
+		accessKey:    result.Credentials.AccessKeyID,
+		secretKey:    result.Credentials.SecretAccessKey,
+		sessionToken: result.Credentials.SessionToken,
+		expiration:   result.Credentials.Expiration,
+	}, nil
+}
+
+func (rt *sigV4RoundTripper) CloseIdleConnections() {
+	if ci, ok := rt.next.(closeIdler); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+// awsDefaultRegion returns the region found in the environment or in the
+// shared config file for the profile.
+func awsDefaultRegion(profile string) string {
+	if region := firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"); region != "" {
+		return region
+	}
+	profile = awsProfile(profile)
+	if profile != "default" {
+		profile = "profile " + profile
+	}
+	section, err := readINISection(awsSharedFilename("AWS_CONFIG_FILE", "config"), profile)
+	if err != nil {
+		return ""
+	}
+	return section["region"]
+}
+
+// awsProfile returns the profile to use when none is configured.
+func awsProfile(profile string) string {
+	if profile != "" {
+		return profile
+	}
+	if profile = os.Getenv("AWS_PROFILE"); profile != "" {
+		return profile
+	}
+	return "default"
+}
+
+// awsSharedFilename returns the file named by the environment variable, or
+// the file with the given name in the .aws directory of the home directory.
+func awsSharedFilename(env, name string) string {
+	if filename := os.Getenv(env); filename != "" {
+		return filename
+	}
+	return filepath.Join(firstEnv("HOME", "USERPROFILE"), ".aws", name)
+}
+
+// firstEnv returns the value of the first non-empty environment variable.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// readINISection returns the keys and values of a section of an INI file.
+func readINISection(filename, section string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseINISection(f, section)
+}
+
+// parseINISection returns the keys and values of a section of the INI data
+// read from r.
+func parseINISection(r io.Reader, section string) (map[string]string, error) {
+	var (
+		values  map[string]string
+		current string
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			if current == section && values == nil {
+				values = map[string]string{}
+			}
+			continue
+		}
+		if current != section {
+			continue
+		}
+		if i := strings.IndexByte(line, '='); i > 0 {
+			values[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if values == nil {
+		return nil, fmt.Errorf("section %q not found", section)
+	}
+	return values, nil
+}
@@ -0,0 +1,320 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The test vectors come from the AWS Signature Version 4 test suite and from
+// the signing examples of the AWS documentation.
+func TestSigV4Signer(t *testing.T) {
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	creds := awsCredentials{accessKey: "AKIDEXAMPLE", secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+
+	testCases := []struct {
+		name          string
+		method        string
+		url           string
+		body          string
+		headers       map[string]string
+		service       string
+		signedHeaders string
+		signature     string
+	}{
+		{
+			name:          "get-vanilla",
+			method:        "GET",
+			url:           "https://example.amazonaws.com/",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		}, {
+			name:          "post-vanilla",
+			method:        "POST",
+			url:           "https://example.amazonaws.com/",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		}, {
+			name:          "get-vanilla-query-order-key-case",
+			method:        "GET",
+			url:           "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			signedHeaders: "host;x-amz-date",
+			signature:     "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		}, {
+			name:          "get-vanilla-query-unreserved",
+			method:        "GET",
+			url:           "https://example.amazonaws.com/?-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			signedHeaders: "host;x-amz-date",
+			signature:     "9c3e54bfcdf0b19771a7f523ee5669cdf59bc7cc0884027167c21bb143a40197",
+		}, {
+			name:          "get-utf8",
+			method:        "GET",
+			url:           "https://example.amazonaws.com/ሴ",
+			signedHeaders: "host;x-amz-date",
+			signature:     "8318018e0b0f223aa2bbf98705b62bb787dc9c0e678f255a891fd03141be5d85",
+		}, {
+			name:          "get-relative",
+			method:        "GET",
+			url:           "https://example.amazonaws.com/example/..",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		}, {
+			name:          "post-x-www-form-urlencoded",
+			method:        "POST",
+			url:           "https://example.amazonaws.com/",
+			body:          "Param1=value1",
+			headers:       map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		}, {
+			name:          "iam-list-users",
+			method:        "GET",
+			url:           "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			headers:       map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+			service:       "iam",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+
+	for _, tc := range testCases {
+		var body io.Reader
+		if tc.body != "" {
+			body = strings.NewReader(tc.body)
+		}
+		req, err := http.NewRequest(tc.method, tc.url, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range tc.headers {
+			req.Header.Set(name, value)
+		}
+		service := tc.service
+		if service == "" {
+			service = "service"
+		}
+		signer := &sigV4Signer{region: "us-east-1", service: service}
+		if err := signer.sign(req, creds, now); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+
+		expected := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/%s/aws4_request, SignedHeaders=%s, Signature=%s",
+			service, tc.signedHeaders, tc.signature)
+		if got := req.Header.Get("Authorization"); got != expected {
+			t.Errorf("%s: expected Authorization header\n%s\ngot\n%s", tc.name, expected, got)
+		}
+		if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+			t.Errorf("%s: expected X-Amz-Date header %q, got %q", tc.name, "20150830T123600Z", got)
+		}
+	}
+}
+
+func TestSigV4RoundTripper(t *testing.T) {
+	const body = "remote write payload"
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(auth, "/eu-west-1/aps/aws4_request") {
+			t.Errorf("Unexpected Authorization header %q", auth)
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Unexpected error reading the body: %v", err)
+		}
+		if string(b) != body {
+			t.Errorf("Expected body %q, got %q", body, b)
+		}
+		fmt.Fprint(w, ExpectedMessage)
+	}))
+	defer testServer.Close()
+
+	cfg, _, err := LoadHTTPConfigFile("testdata/http.conf.sigv4.good.yml")
+	if err != nil {
+		t.Fatalf("Error loading HTTP client config: %v", err)
+	}
+	client, err := NewClientFromConfig(*cfg, "test", false)
+	if err != nil {
+		t.Fatalf("Error creating HTTP Client: %v", err)
+	}
+
+	// The body can't be read again, so it is buffered to be hashed.
+	resp, err := client.Post(testServer.URL, "application/x-protobuf", ioutil.NopCloser(strings.NewReader(body)))
+	if err != nil {
+		t.Fatalf("Can't connect to the test server: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestSigV4SharedCredentialsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sigv4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	credentials := filepath.Join(dir, "credentials")
+	if err := ioutil.WriteFile(credentials, []byte("[default]\naws_access_key_id = AKIDDEFAULT\naws_secret_access_key = default\n\n[remote-write]\naws_access_key_id = AKIDREMOTEWRITE\naws_secret_access_key = secret\naws_session_token = token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(config, []byte("[profile remote-write]\nregion = ap-southeast-2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer setEnv(map[string]string{
+		"AWS_SHARED_CREDENTIALS_FILE": credentials,
+		"AWS_CONFIG_FILE":             config,
+		"AWS_REGION":                  "",
+		"AWS_DEFAULT_REGION":          "",
+		"AWS_ACCESS_KEY_ID":           "",
+		"AWS_PROFILE":                 "",
+	})()
+
+	expectedKey := "AKIDREMOTEWRITE"
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); !strings.Contains(auth, "Credential="+expectedKey+"/") || !strings.Contains(auth, "/ap-southeast-2/execute-api/") {
+			t.Errorf("Unexpected Authorization header %q", auth)
+		}
+		if token := r.Header.Get("X-Amz-Security-Token"); token != "token" {
+			t.Errorf("Expected X-Amz-Security-Token header %q, got %q", "token", token)
+		}
+	}))
+	defer testServer.Close()
+
+	rt, err := NewSigV4RoundTripper(&SigV4{Profile: "remote-write", Service: "execute-api"}, http.DefaultTransport)
+	if err != nil {
+		t.Fatalf("Error creating round tripper: %v", err)
+	}
+	client := &http.Client{Transport: rt}
+	get := func() {
+		t.Helper()
+		resp, err := client.Get(testServer.URL)
+		if err != nil {
+			t.Fatalf("Can't connect to the test server: %v", err)
+		}
+		resp.Body.Close()
+	}
+	get()
+
+	// The changes of the file are picked up.
+	if err := ioutil.WriteFile(credentials, []byte("[remote-write]\naws_access_key_id = AKIDROTATED\naws_secret_access_key = rotated\naws_session_token = token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	expectedKey = "AKIDROTATED"
+	get()
+
+	// The last credentials are kept if the file can't be read anymore.
+	if err := os.Remove(credentials); err != nil {
+		t.Fatal(err)
+	}
+	get()
+
+	_, err = NewSigV4RoundTripper(&SigV4{}, http.DefaultTransport)
+	if err == nil || err.Error() != "sigv4 region must be configured or found in the environment" {
+		t.Errorf("Expected error about the missing region, got %v", err)
+	}
+}
+
+func TestSigV4AssumeRole(t *testing.T) {
+	var stsCalls int64
+	stsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&stsCalls, 1)
+		if auth := r.Header.Get("Authorization"); !strings.Contains(auth, "Credential=AKIDEXAMPLE/") || !strings.Contains(auth, "/us-east-1/sts/aws4_request") {
+			t.Errorf("Unexpected Authorization header %q", auth)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("Unexpected error parsing the form: %v", err)
+		}
+		if r.Form.Get("Action") != "AssumeRole" || r.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/remote-write" {
+			t.Errorf("Unexpected STS request %v", r.Form)
+		}
+		fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAROLE</AccessKeyId>
+      <SecretAccessKey>rolesecret</SecretAccessKey>
+      <SessionToken>roletoken</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer stsServer.Close()
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); !strings.Contains(auth, "Credential=ASIAROLE/") {
+			t.Errorf("Unexpected Authorization header %q", auth)
+		}
+		if token := r.Header.Get("X-Amz-Security-Token"); token != "roletoken" {
+			t.Errorf("Expected X-Amz-Security-Token header %q, got %q", "roletoken", token)
+		}
+	}))
+	defer testServer.Close()
+
+	rt, err := NewSigV4RoundTripper(&SigV4{
+		Region:    "us-east-1",
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: NewSecretValue("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"),
+		RoleARN:   "arn:aws:iam::123456789012:role/remote-write",
+	}, http.DefaultTransport)
+	if err != nil {
+		t.Fatalf("Error creating round tripper: %v", err)
+	}
+	rt.(*sigV4RoundTripper).stsEndpoint = stsServer.URL
+
+	client := &http.Client{Transport: rt}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(testServer.URL)
+		if err != nil {
+			t.Fatalf("Can't connect to the test server: %v", err)
+		}
+		resp.Body.Close()
+	}
+	// The temporary credentials are cached until they are about to expire.
+	if n := atomic.LoadInt64(&stsCalls); n != 1 {
+		t.Errorf("Expected 1 call to STS, got %d", n)
+	}
+}
+
+// setEnv sets the environment variables and returns a function restoring
+// their previous values.
+func setEnv(vars map[string]string) func() {
+	old := make(map[string]*string, len(vars))
+	for name, value := range vars {
+		if v, ok := os.LookupEnv(name); ok {
+			old[name] = &v
+		} else {
+			old[name] = nil
+		}
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, v := range old {
+			if v == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *v)
+			}
+		}
+	}
+}
@@ -17,10 +17,19 @@ package config
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/prometheus/common/model"
 	"gopkg.in/yaml.v2"
 )
 
@@ -47,6 +56,18 @@ var expectedTLSConfigs = []struct {
 	}, {
 		filename: "tls_config.insecure.good.yml",
 		config:   &tls.Config{InsecureSkipVerify: true},
+	}, {
+		filename: "tls_config.versions.good.yml",
+		config: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			MaxVersion: versionTLS13,
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				0x1301, // TLS_AES_128_GCM_SHA256, only available from Go 1.12.
+			},
+			CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		},
 	},
 }
 
@@ -63,3 +84,338 @@ func TestValidTLSConfig(t *testing.T) {
 		}
 	}
 }
+
+var invalidTLSConfigs = []struct {
+	filename string
+	errMsg   string
+}{
+	{
+		filename: "tls_config.cert_no_key.bad.yml",
+		errMsg:   "client cert somefile specified without client key",
+	}, {
+		filename: "tls_config.key_no_cert.bad.yml",
+		errMsg:   "client key somefile specified without client cert",
+	}, {
+		filename: "tls_config.invalid_field.bad.yml",
+		errMsg:   "field something_invalid not found",
+	}, {
+		filename: "tls_config.ca_and_ca_file.bad.yml",
+		errMsg:   "at most one of ca & ca_file must be configured",
+	}, {
+		filename: "tls_config.cert_and_cert_file.bad.yml",
+		errMsg:   "at most one of cert & cert_file must be configured",
+	}, {
+		filename: "tls_config.key_and_key_file.bad.yml",
+		errMsg:   "at most one of key & key_file must be configured",
+	}, {
+		filename: "tls_config.min_greater_than_max.bad.yml",
+		errMsg:   "min_version TLS13 must not be greater than max_version TLS12",
+	}, {
+		filename: "tls_config.unknown_version.bad.yml",
+		errMsg:   "unknown TLS version: SSL30",
+	}, {
+		filename: "tls_config.unknown_cipher.bad.yml",
+		errMsg:   "unknown TLS cipher suite: TLS_UNKNOWN_CIPHER",
+	}, {
+		filename: "tls_config.unknown_curve.bad.yml",
+		errMsg:   "unknown TLS curve: CurveP999",
+	}, {
+		filename: "tls_config.invalid_pin.bad.yml",
+		errMsg:   `invalid pinned_spki_sha256 "not-a-pin": must be a base64 encoded SHA-256 hash`,
+	}, {
+		filename: "tls_config.key_password_and_file.bad.yml",
+		errMsg:   "at most one of key_password & key_password_file must be configured",
+	}, {
+		filename: "tls_config.key_password_no_key.bad.yml",
+		errMsg:   "client key password somefile specified without client key",
+	},
+}
+
+func TestInvalidTLSConfig(t *testing.T) {
+	for _, ee := range invalidTLSConfigs {
+		_, err := LoadTLSConfig("testdata/" + ee.filename)
+		if err == nil {
+			t.Errorf("%s: expected error but got none", ee.filename)
+			continue
+		}
+		if !strings.Contains(err.Error(), ee.errMsg) {
+			t.Errorf("%s: expected error to contain %q but got: %s", ee.filename, ee.errMsg, err)
+		}
+	}
+}
+
+func TestTLSConfigMarshalYAMLRoundTrip(t *testing.T) {
+	for _, filename := range []string{
+		"tls_config.versions.good.yml",
+		"tls_config.insecure.good.yml",
+	} {
+		content, err := ioutil.ReadFile("testdata/" + filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := TLSConfig{}
+		if err = yaml.UnmarshalStrict(content, &cfg); err != nil {
+			t.Fatalf("%s: %s", filename, err)
+		}
+		out, err := yaml.Marshal(&cfg)
+		if err != nil {
+			t.Fatalf("%s: %s", filename, err)
+		}
+		if string(out) != string(content) {
+			t.Errorf("%s: unexpected marshalled config: \n\n%s\n expected\n\n%s", filename, out, content)
+		}
+	}
+}
+
+func TestTLSConfigJSON(t *testing.T) {
+	for _, cfg := range expectedTLSConfigs {
+		content, err := ioutil.ReadFile("testdata/" + cfg.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := TLSConfig{}
+		if err = yaml.UnmarshalStrict(content, &expected); err != nil {
+			t.Fatalf("%s: %s", cfg.filename, err)
+		}
+		b, err := json.Marshal(&expected)
+		if err != nil {
+			t.Fatalf("%s: %s", cfg.filename, err)
+		}
+		got := TLSConfig{}
+		if err = json.Unmarshal(b, &got); err != nil {
+			t.Fatalf("%s: %s", cfg.filename, err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: unexpected config after JSON round trip: \n\n%+v\n expected\n\n%+v", cfg.filename, got, expected)
+		}
+	}
+
+	b, err := json.Marshal(TLSConfig{MinVersion: TLSVersion(tls.VersionTLS12), CipherSuites: []TLSCipherSuite{cipherSuites["TLS_AES_128_GCM_SHA256"]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"insecure_skip_verify":false,"min_version":"TLS12","cipher_suites":["TLS_AES_128_GCM_SHA256"]}`
+	if string(b) != expected {
+		t.Errorf("Expected %s, got %s", expected, b)
+	}
+}
+
+func TestInvalidTLSConfigJSON(t *testing.T) {
+	for _, ee := range invalidTLSConfigs {
+		if ee.filename == "tls_config.invalid_field.bad.yml" {
+			// Unknown JSON fields are ignored.
+			continue
+		}
+		content, err := ioutil.ReadFile("testdata/" + ee.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := yamlToJSON(content)
+		if err != nil {
+			t.Fatalf("%s: %s", ee.filename, err)
+		}
+		cfg := TLSConfig{}
+		err = json.Unmarshal(b, &cfg)
+		if err == nil {
+			t.Errorf("%s: expected error but got none", ee.filename)
+			continue
+		}
+		if !strings.Contains(err.Error(), ee.errMsg) {
+			t.Errorf("%s: expected error to contain %q but got: %s", ee.filename, ee.errMsg, err)
+		}
+	}
+}
+
+func TestTLSConfigCADir(t *testing.T) {
+	bs := getCertificateBlobs(t)
+	tmpDir, err := ioutil.TempDir("", "cadir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testServer, err := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ExpectedMessage)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testServer.Close()
+
+	// Files without the .pem or .crt extension are ignored.
+	writeCertificate(bs, WrongClientCertPath, filepath.Join(tmpDir, "self-signed.pem"))
+	writeCertificate(bs, ClientKeyNoPassPath, filepath.Join(tmpDir, "README"))
+	writeCertificate(bs, ClientKeyNoPassPath, filepath.Join(tmpDir, ".hidden.pem"))
+
+	var reloadErr error
+	cfg := HTTPClientConfig{
+		TLSConfig: TLSConfig{
+			CADir:      tmpDir,
+			CertFile:   ClientCertificatePath,
+			KeyFile:    ClientKeyNoPassPath,
+			ReloadHook: func(err error) { reloadErr = err },
+		},
+	}
+	client, err := NewClientFromConfig(cfg, "test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() error {
+		resp, err := client.Get(testServer.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	if err := get(); err == nil || !strings.Contains(err.Error(), "certificate signed by unknown authority") {
+		t.Fatalf("Expected unknown authority error, got %v", err)
+	}
+
+	// Adding a file to the directory reloads the CA certs.
+	writeCertificate(bs, TLSCAChainPath, filepath.Join(tmpDir, "ca.crt"))
+	if err := get(); err != nil {
+		t.Fatalf("Unexpected error after adding the CA: %v", err)
+	}
+
+	// Invalid files are reported and the previous CA certs are kept.
+	invalid := filepath.Join(tmpDir, "invalid.pem")
+	writeCertificate(bs, EmptyFile, invalid)
+	if err := get(); err != nil {
+		t.Fatalf("Unexpected error with an invalid CA file: %v", err)
+	}
+	if reloadErr == nil || reloadErr.Error() != "unable to use CA cert "+invalid {
+		t.Errorf("Expected reload error for %s, got %v", invalid, reloadErr)
+	}
+
+	// Removing the CA from the directory reloads the CA certs.
+	os.Remove(invalid)
+	os.Remove(filepath.Join(tmpDir, "ca.crt"))
+	if err := get(); err == nil || !strings.Contains(err.Error(), "certificate signed by unknown authority") {
+		t.Fatalf("Expected unknown authority error after removing the CA, got %v", err)
+	}
+
+	_, err = NewTLSConfig(&TLSConfig{CADir: filepath.Join(tmpDir, "missing")})
+	if err == nil || !strings.Contains(err.Error(), "unable to load specified CA dir") {
+		t.Errorf("Expected error for the missing directory, got %v", err)
+	}
+
+	// An empty directory is valid.
+	emptyDir := filepath.Join(tmpDir, "empty")
+	if err := os.Mkdir(emptyDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	appendSystem := []bool{false}
+	if _, err := x509.SystemCertPool(); err == nil {
+		appendSystem = append(appendSystem, true)
+	}
+	for _, a := range appendSystem {
+		cfg := TLSConfig{CADir: emptyDir, CAAppendSystem: a}
+		if _, err := NewTLSConfig(&cfg); err != nil {
+			t.Errorf("Unexpected error with an empty directory (ca_append_system: %t): %v", a, err)
+		}
+		if _, err := NewRoundTripperFromConfig(HTTPClientConfig{TLSConfig: cfg}, "test", false); err != nil {
+			t.Errorf("Unexpected error with an empty directory (ca_append_system: %t): %v", a, err)
+		}
+	}
+}
+
+func TestCADirRefreshInterval(t *testing.T) {
+	bs := getCertificateBlobs(t)
+	tmpDir, err := ioutil.TempDir("", "cadir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Now()
+	c := &caDirCache{refreshInterval: time.Minute, now: func() time.Time { return now }}
+	if b, err := c.read(tmpDir); err != nil || len(b) != 0 {
+		t.Fatalf("Expected no CA cert, got %q, %v", b, err)
+	}
+
+	// The directory isn't checked again within the refresh interval.
+	writeCertificate(bs, TLSCAChainPath, filepath.Join(tmpDir, "ca.crt"))
+	if b, err := c.read(tmpDir); err != nil || len(b) != 0 {
+		t.Fatalf("Expected no CA cert within the refresh interval, got %q, %v", b, err)
+	}
+
+	now = now.Add(time.Minute)
+	b, err := c.read(tmpDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := string(bs[TLSCAChainPath]) + "\n"; string(b) != expected {
+		t.Errorf("Expected the CA certs of %s, got %q", TLSCAChainPath, b)
+	}
+}
+
+func TestTLSFilesRefreshInterval(t *testing.T) {
+	bs := getCertificateBlobs(t)
+	tmpDir, err := ioutil.TempDir("", "tlsfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testServer, err := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ExpectedMessage)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testServer.Close()
+
+	ca := filepath.Join(tmpDir, "ca.pem")
+	writeCertificate(bs, TLSCAChainPath, ca)
+	var reloads []error
+	cfg := HTTPClientConfig{
+		TLSConfig: TLSConfig{
+			CAFile:     ca,
+			CertFile:   ClientCertificatePath,
+			KeyFile:    ClientKeyNoPassPath,
+			ReloadHook: func(err error) { reloads = append(reloads, err) },
+		},
+		CredentialsFileRefreshInterval: model.Duration(time.Hour),
+	}
+	client, err := NewClientFromConfig(cfg, "test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The CA file isn't checked again within the refresh interval, the
+	// invalid content is neither read nor reported.
+	writeCertificate(bs, EmptyFile, ca)
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(testServer.URL)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if len(reloads) != 0 {
+		t.Errorf("Expected no reload within the refresh interval, got %v", reloads)
+	}
+}
+
+func TestTLSConfigCAAppendSystem(t *testing.T) {
+	system, err := x509.SystemCertPool()
+	if err != nil {
+		t.Skipf("System cert pool not available: %v", err)
+	}
+
+	for _, appendSystem := range []bool{false, true} {
+		tlsConfig, err := NewTLSConfig(&TLSConfig{CAFile: TLSCAChainPath, CAAppendSystem: appendSystem})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The CA chain holds 2 certificates.
+		expected := 2
+		if appendSystem {
+			expected += len(system.Subjects())
+		}
+		if got := len(tlsConfig.RootCAs.Subjects()); got != expected {
+			t.Errorf("ca_append_system %v: expected %d CA certs, got %d", appendSystem, expected, got)
+		}
+	}
+}
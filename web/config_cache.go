@@ -0,0 +1,128 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// configCache holds the last good web configuration, along with the TLS
+// configuration built from it when TLS is enabled. The configuration is
+// loaded again when the modification time or the size of the configuration
+// file or of the TLS files it references changes. If the new files can't be
+// used, the error is logged once and the last good configuration is kept.
+type configCache struct {
+	path   string
+	logger log.Logger
+	// tls is true if the server serves TLS, which can't be disabled without
+	// restarting it.
+	tls bool
+
+	mtx       sync.Mutex
+	config    *Config
+	tlsConfig *tls.Config
+	// stamps identifies the files of the current configuration, failed
+	// the last files which couldn't be used.
+	stamps, failed string
+}
+
+// newConfigCache loads the web configuration found at path. It fails if the
+// configuration can't be used.
+func newConfigCache(path string, logger log.Logger) (*configCache, error) {
+	c := &configCache{path: path, logger: logger}
+	stamps := c.fileStamps(nil)
+	config, err := LoadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c.tls = config.TLSConfig.enabled()
+	if c.tls {
+		if c.tlsConfig, err = NewTLSConfig(&config.TLSConfig); err != nil {
+			return nil, err
+		}
+	}
+	c.config = config
+	c.stamps = stamps + c.fileStamps(config)
+	return c, nil
+}
+
+// get returns the current configuration and the TLS configuration built
+// from it, if any, after loading them again if the files have changed.
+func (c *configCache) get() (*Config, *tls.Config) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	configStamps := c.fileStamps(nil)
+	stamps := configStamps + c.fileStamps(c.config)
+	if stamps == c.stamps || stamps == c.failed {
+		return c.config, c.tlsConfig
+	}
+	config, tlsConfig, err := c.load()
+	if err != nil {
+		level.Error(c.logger).Log("msg", "Unable to reload web configuration, keeping the previous one", "err", err)
+		c.failed = stamps
+		return c.config, c.tlsConfig
+	}
+	c.config, c.tlsConfig = config, tlsConfig
+	// The new configuration may reference other TLS files.
+	c.stamps = configStamps + c.fileStamps(config)
+	c.failed = ""
+	return c.config, c.tlsConfig
+}
+
+// load loads the configuration file and builds the TLS configuration if the
+// server serves TLS.
+func (c *configCache) load() (*Config, *tls.Config, error) {
+	config, err := LoadConfigFile(c.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !c.tls {
+		return config, nil, nil
+	}
+	tlsConfig, err := NewTLSConfig(&config.TLSConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, tlsConfig, nil
+}
+
+// fileStamps describes the modification time and size of the configuration
+// file if config is nil, otherwise of the TLS files of config.
+func (c *configCache) fileStamps(config *Config) string {
+	paths := []string{c.path}
+	if config != nil {
+		paths = []string{config.TLSConfig.CertFile, config.TLSConfig.KeyFile, config.TLSConfig.ClientCAFile}
+	}
+	var b bytes.Buffer
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			fmt.Fprintf(&b, "%s:%s\n", p, err)
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%d:%d\n", p, fi.ModTime().UnixNano(), fi.Size())
+	}
+	return b.String()
+}
@@ -0,0 +1,243 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package web provides the server counterpart of config.HTTPClientConfig:
+// a YAML configuration enabling TLS and basic authentication for HTTP
+// servers, along with helpers to serve HTTP using it.
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/prometheus/common/config"
+)
+
+// Config is the configuration of a web server.
+type Config struct {
+	// TLSConfig enables TLS when its certificate and key are configured.
+	TLSConfig TLSServerConfig `yaml:"tls_server_config"`
+	// Users maps user names to bcrypt-hashed passwords. If not empty, all
+	// requests require basic authentication.
+	Users map[string]config.SecretValue `yaml:"basic_auth_users"`
+}
+
+// TLSServerConfig configures the options for TLS connections to a web server.
+type TLSServerConfig struct {
+	// The server certificate file.
+	CertFile string `yaml:"cert_file"`
+	// The server key file.
+	KeyFile string `yaml:"key_file"`
+	// The policy for client authentication, one of the names of
+	// crypto/tls.ClientAuthType such as "RequireAndVerifyClientCert".
+	ClientAuth string `yaml:"client_auth_type"`
+	// The CA file used to verify client certificates.
+	ClientCAFile string `yaml:"client_ca_file"`
+	// Minimum acceptable TLS version. Defaults to TLS12.
+	MinVersion config.TLSVersion `yaml:"min_version"`
+}
+
+// clientAuthTypes maps the YAML names of client authentication policies to
+// their values.
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// enabled returns true if the configuration requires TLS.
+func (c *TLSServerConfig) enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.ClientAuth != "" || c.ClientCAFile != ""
+}
+
+// SetDirectory joins any relative file paths with dir.
+func (c *TLSServerConfig) SetDirectory(dir string) {
+	c.CertFile = config.JoinDir(dir, c.CertFile)
+	c.KeyFile = config.JoinDir(dir, c.KeyFile)
+	c.ClientCAFile = config.JoinDir(dir, c.ClientCAFile)
+}
+
+// SetDirectory joins any relative file paths with dir.
+func (c *Config) SetDirectory(dir string) {
+	c.TLSConfig.SetDirectory(dir)
+	for user, hash := range c.Users {
+		hash.SetDirectory(dir)
+		c.Users[user] = hash
+	}
+}
+
+// LoadConfigFile parses the given YAML file into a Config. Relative file
+// paths are resolved against the directory of the file.
+func LoadConfigFile(filename string) (*Config, error) {
+	c := &Config{}
+	if err := config.LoadFile(filename, c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate validates the Config to check that the TLS settings are
+// consistent and that all passwords are bcrypt hashes.
+func (c *Config) Validate() error {
+	if c.TLSConfig.enabled() {
+		if c.TLSConfig.CertFile == "" {
+			return fmt.Errorf("missing cert_file")
+		}
+		if c.TLSConfig.KeyFile == "" {
+			return fmt.Errorf("missing key_file")
+		}
+	}
+	clientAuth, ok := clientAuthTypes[c.TLSConfig.ClientAuth]
+	if !ok {
+		return fmt.Errorf("invalid client_auth_type: %s", c.TLSConfig.ClientAuth)
+	}
+	if c.TLSConfig.ClientCAFile != "" && clientAuth == tls.NoClientCert {
+		return fmt.Errorf("client_ca_file configured without a client_auth_type")
+	}
+	return validateUsers(c.Users)
+}
+
+// NewTLSConfig creates a new tls.Config from the given TLSServerConfig.
+func NewTLSConfig(c *TLSServerConfig) (*tls.Config, error) {
+	clientAuth, ok := clientAuthTypes[c.ClientAuth]
+	if !ok {
+		return nil, fmt.Errorf("invalid client_auth_type: %s", c.ClientAuth)
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to use specified server cert (%s) & key (%s): %s", c.CertFile, c.KeyFile, err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuth,
+		MinVersion:   uint16(c.MinVersion),
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+
+	if c.ClientCAFile != "" {
+		b, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load specified client CA cert %s: %s", c.ClientCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("unable to use specified client CA cert %s", c.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+	}
+	return cfg, nil
+}
+
+// ListenAndServe listens on server.Addr and serves HTTP using the web
+// configuration found at configPath. See Serve for details.
+func ListenAndServe(server *http.Server, configPath string, logger log.Logger) error {
+	addr := server.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return Serve(l, server, configPath, logger)
+}
+
+// Serve serves HTTP on the listener using the web configuration found at
+// configPath. If configPath is empty or the configuration doesn't enable
+// TLS, the server falls back to plain HTTP. The configuration is loaded
+// again when the configuration file or the TLS files change, so that new
+// certificates and users are picked up without restarting. If the changed
+// files can't be used, the last good configuration is kept.
+func Serve(l net.Listener, server *http.Server, configPath string, logger log.Logger) error {
+	if configPath == "" {
+		level.Info(logger).Log("msg", "TLS is disabled")
+		return server.Serve(l)
+	}
+
+	cache, err := newConfigCache(configPath, logger)
+	if err != nil {
+		return err
+	}
+
+	handler := server.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	server.Handler = &basicAuthHandler{
+		cache:   cache,
+		handler: handler,
+		logger:  logger,
+	}
+
+	if !cache.tls {
+		level.Info(logger).Log("msg", "TLS is disabled")
+		return server.Serve(l)
+	}
+
+	_, tlsConfig := cache.get()
+	// The cached configurations are returned for the new connections, set
+	// the hook on a copy.
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		_, cfg := cache.get()
+		// The protocols negotiated with ALPN are the ones of the returned
+		// config, keep those set up by ServeTLS, such as h2.
+		cfg = cfg.Clone()
+		cfg.NextProtos = nextProtos(server)
+		return cfg, nil
+	}
+	server.TLSConfig = tlsConfig
+
+	level.Info(logger).Log("msg", "TLS is enabled")
+	return server.ServeTLS(l, "", "")
+}
+
+// nextProtos returns the protocols that ServeTLS negotiates with ALPN: the
+// ones of server.TLSConfig, h2 if HTTP/2 is set up and http/1.1. Depending on
+// the Go version, they are set on server.TLSConfig or only on the copy used
+// by the listener.
+func nextProtos(server *http.Server) []string {
+	protos := append([]string(nil), server.TLSConfig.NextProtos...)
+	if _, ok := server.TLSNextProto["h2"]; ok && !containsString(protos, "h2") {
+		protos = append(protos, "h2")
+	}
+	if !containsString(protos, "http/1.1") {
+		protos = append(protos, "http/1.1")
+	}
+	return protos
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,266 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/prometheus/common/config"
+)
+
+const ExpectedMessage = "I'm here to serve you!!!"
+
+var invalidConfigs = []struct {
+	filename string
+	errMsg   string
+}{
+	{
+		filename: "web_config.missing-key.bad.yml",
+		errMsg:   "missing key_file",
+	}, {
+		filename: "web_config.missing-cert.bad.yml",
+		errMsg:   "missing cert_file",
+	}, {
+		filename: "web_config.bad-client-auth.bad.yml",
+		errMsg:   "invalid client_auth_type: Bogus",
+	}, {
+		filename: "web_config.ca-without-auth.bad.yml",
+		errMsg:   "client_ca_file configured without a client_auth_type",
+	}, {
+		filename: "web_config.plain-password.bad.yml",
+		errMsg:   `invalid password hash for user "arthurdent"`,
+	}, {
+		filename: "web_config.bad-version.bad.yml",
+		errMsg:   "unknown TLS version: SSL30",
+	},
+}
+
+func TestInvalidConfigs(t *testing.T) {
+	for _, ee := range invalidConfigs {
+		_, err := LoadConfigFile("testdata/" + ee.filename)
+		if err == nil {
+			t.Errorf("%s: expected error but got none", ee.filename)
+			continue
+		}
+		if !strings.Contains(err.Error(), ee.errMsg) {
+			t.Errorf("%s: expected error to contain %q but got: %s", ee.filename, ee.errMsg, err)
+		}
+	}
+}
+
+func TestLoadConfigFileRelativePaths(t *testing.T) {
+	c, err := LoadConfigFile("testdata/web_config.tls.good.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("testdata", "server.crt"); c.TLSConfig.CertFile != want {
+		t.Errorf("Expected cert_file %q, got %q", want, c.TLSConfig.CertFile)
+	}
+	if want := filepath.Join("testdata", "tls-ca-chain.pem"); c.TLSConfig.ClientCAFile != want {
+		t.Errorf("Expected client_ca_file %q, got %q", want, c.TLSConfig.ClientCAFile)
+	}
+}
+
+// serve starts serving on a random local port with the given web
+// configuration. It returns the listening address and a function stopping
+// the server.
+func serve(t *testing.T, configPath string) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, ExpectedMessage)
+		}),
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- Serve(l, server, configPath, log.NewNopLogger())
+	}()
+	return l.Addr().String(), func() {
+		server.Close()
+		if err := <-errc; err != http.ErrServerClosed {
+			t.Errorf("Unexpected error from Serve: %s", err)
+		}
+	}
+}
+
+func get(client *http.Client, url string) (string, error) {
+	r, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer r.Body.Close()
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	if r.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code %d", r.StatusCode)
+	}
+	return string(b), nil
+}
+
+func TestServeTLS(t *testing.T) {
+	addr, stop := serve(t, "testdata/web_config.tls.good.yml")
+	defer stop()
+
+	testCases := []struct {
+		tlsConfig config.TLSConfig
+		errMsg    string
+	}{
+		{
+			tlsConfig: config.TLSConfig{
+				CAFile:   "testdata/tls-ca-chain.pem",
+				CertFile: "testdata/client.crt",
+				KeyFile:  "testdata/client-no-pass.key",
+			},
+		}, {
+			// No client certificate.
+			tlsConfig: config.TLSConfig{
+				CAFile: "testdata/tls-ca-chain.pem",
+			},
+			errMsg: "remote error: tls",
+		}, {
+			// Any certificate signed by the client CA is accepted.
+			tlsConfig: config.TLSConfig{
+				CAFile:   "testdata/tls-ca-chain.pem",
+				CertFile: "testdata/server.crt",
+				KeyFile:  "testdata/server.key",
+			},
+		},
+	}
+	for i, tc := range testCases {
+		client, err := config.NewClientFromConfig(config.HTTPClientConfig{TLSConfig: tc.tlsConfig}, "test", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := get(client, "https://"+addr)
+		if tc.errMsg != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+				t.Errorf("%d: expected error containing %q, got %v", i, tc.errMsg, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if got != ExpectedMessage {
+			t.Errorf("%d: expected %q, got %q", i, ExpectedMessage, got)
+		}
+	}
+}
+
+func TestServeTLSHTTP2(t *testing.T) {
+	addr, stop := serve(t, "testdata/web_config.tls.good.yml")
+	defer stop()
+
+	cert, err := tls.LoadX509KeyPair("testdata/client.crt", "testdata/client-no-pass.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ioutil.ReadFile("testdata/tls-ca-chain.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   "localhost",
+		NextProtos:   []string{"h2", "http/1.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if p := conn.ConnectionState().NegotiatedProtocol; p != "h2" {
+		t.Errorf("Expected HTTP/2 to be negotiated, got %q", p)
+	}
+}
+
+func TestServeNoTLS(t *testing.T) {
+	for _, configPath := range []string{"", "testdata/web_config.notls.good.yml"} {
+		addr, stop := serve(t, configPath)
+		got, err := get(http.DefaultClient, "http://"+addr)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", configPath, err)
+		} else if got != ExpectedMessage {
+			t.Errorf("%q: expected %q, got %q", configPath, ExpectedMessage, got)
+		}
+		stop()
+	}
+}
+
+func TestServeTLSReload(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, f := range []string{"server.crt", "server.key", "tls-ca-chain.pem"} {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, f), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	configPath := filepath.Join(tmpDir, "web.yml")
+	writeConfig := func(minVersion string) {
+		cfg := fmt.Sprintf("tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  min_version: %s\n", minVersion)
+		if err := ioutil.WriteFile(configPath, []byte(cfg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeConfig("TLS12")
+	addr, stop := serve(t, configPath)
+	defer stop()
+
+	client, err := config.NewClientFromConfig(config.HTTPClientConfig{
+		TLSConfig: config.TLSConfig{
+			CAFile:     filepath.Join(tmpDir, "tls-ca-chain.pem"),
+			MaxVersion: config.TLSVersion(tls.VersionTLS12),
+		},
+	}, "test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(client, "https://"+addr); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// New connections must use the updated configuration.
+	writeConfig("TLS13")
+	if _, err := get(client, "https://"+addr); err == nil || !strings.Contains(err.Error(), "protocol version") {
+		t.Fatalf("Expected a protocol version error, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/prometheus/common/config"
+)
+
+// unknownUserHash is compared against the passwords of unknown users so that
+// they take as long to reject as known users.
+var unknownUserHash = func() []byte {
+	h, err := bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}()
+
+// validateUsers checks that all passwords are bcrypt hashes. The passwords
+// referencing a secret provider are checked when they are used.
+func validateUsers(users map[string]config.SecretValue) error {
+	for user, hash := range users {
+		if _, _, ok := hash.Ref(); ok {
+			continue
+		}
+		h, err := hash.Resolve(context.Background())
+		if err != nil {
+			return err
+		}
+		if _, err := bcrypt.Cost([]byte(h)); err != nil {
+			return fmt.Errorf("invalid password hash for user %q: %s", user, err)
+		}
+	}
+	return nil
+}
+
+// maxAuthChecks bounds the number of successful password checks remembered
+// by authChecks.
+const maxAuthChecks = 1024
+
+// authChecks remembers the successful password checks, so that bcrypt runs
+// once per user, password and password hash rather than for every request.
+// The zero value is ready to use.
+type authChecks struct {
+	mtx sync.Mutex
+	ok  map[[sha256.Size]byte]struct{}
+}
+
+// authCheckKey returns the key of the check of the password against the hash
+// of the user.
+func authCheckKey(user, pass, hash string) [sha256.Size]byte {
+	h := sha256.New()
+	for _, s := range []string{user, pass, hash} {
+		fmt.Fprintf(h, "%d:%s", len(s), s)
+	}
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// compare returns true if the password matches the hash of the user.
+func (c *authChecks) compare(user, pass, hash string) bool {
+	key := authCheckKey(user, pass, hash)
+	c.mtx.Lock()
+	_, ok := c.ok[key]
+	c.mtx.Unlock()
+	if ok {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+		return false
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.ok == nil || len(c.ok) >= maxAuthChecks {
+		c.ok = map[[sha256.Size]byte]struct{}{}
+	}
+	c.ok[key] = struct{}{}
+	return true
+}
+
+// basicAuthHandler is a http.Handler requiring basic authentication for the
+// users found in the web configuration, if any.
+type basicAuthHandler struct {
+	cache   *configCache
+	handler http.Handler
+	logger  log.Logger
+	checks  authChecks
+}
+
+func (h *basicAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c, _ := h.cache.get()
+	ok, err := h.authenticate(c.Users, r)
+	if err != nil {
+		level.Error(h.logger).Log("msg", "Unable to authenticate user", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if len(c.Users) == 0 || ok {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("WWW-Authenticate", "Basic")
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// authenticate returns true if the request carries the credentials of one of
+// the users.
+func (h *basicAuthHandler) authenticate(users map[string]config.SecretValue, r *http.Request) (bool, error) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false, nil
+	}
+	secret, known := users[user]
+	if !known {
+		bcrypt.CompareHashAndPassword(unknownUserHash, []byte(pass))
+		return false, nil
+	}
+	hash, err := secret.Resolve(r.Context())
+	if err != nil {
+		return false, fmt.Errorf("unable to resolve password hash for user %q: %s", user, err)
+	}
+	return h.checks.compare(user, pass, hash), nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2020 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestBasicAuthHandler(t *testing.T) {
+	// The password hash of zaphod references this environment variable.
+	os.Setenv("WEB_TEST_ZAPHOD_PASSWORD_HASH", "$2a$04$N0dxXCyWrmCuxF1oWfYmPufFgUzfc4CRy6CzbbHYmdpGVd40oJIOa")
+	defer os.Unsetenv("WEB_TEST_ZAPHOD_PASSWORD_HASH")
+
+	cache, err := newConfigCache("testdata/web_config.users.good.yml", log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &basicAuthHandler{
+		cache: cache,
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, ExpectedMessage)
+		}),
+		logger: log.NewNopLogger(),
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	testCases := []struct {
+		username, password string
+		noAuth             bool
+		code               int
+	}{
+		{username: "arthurdent", password: "dent", code: http.StatusOK},
+		{username: "arthurdent", password: "42", code: http.StatusUnauthorized},
+		{username: "fordprefect", password: "dent", code: http.StatusUnauthorized},
+		{username: "zaphod", password: "dent", code: http.StatusOK},
+		{username: "zaphod", password: "42", code: http.StatusUnauthorized},
+		{noAuth: true, code: http.StatusUnauthorized},
+	}
+	for _, tc := range testCases {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tc.noAuth {
+			req.SetBasicAuth(tc.username, tc.password)
+		}
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+		if r.StatusCode != tc.code {
+			t.Errorf("%s:%s: expected status code %d, got %d", tc.username, tc.password, tc.code, r.StatusCode)
+		}
+		if r.StatusCode == http.StatusUnauthorized && r.Header.Get("WWW-Authenticate") != "Basic" {
+			t.Errorf("%s:%s: missing WWW-Authenticate header", tc.username, tc.password)
+		}
+	}
+}
+
+func TestBasicAuthHandlerChecks(t *testing.T) {
+	cache, err := newConfigCache("testdata/web_config.users.good.yml", log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &basicAuthHandler{
+		cache: cache,
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, ExpectedMessage)
+		}),
+		logger: log.NewNopLogger(),
+	}
+	expectStatus := func(pass string, code int) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth("arthurdent", pass)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != code {
+			t.Errorf("%s: expected status code %d, got %d", pass, code, w.Code)
+		}
+	}
+
+	// Only the successful checks are remembered.
+	expectStatus("42", http.StatusUnauthorized)
+	if len(handler.checks.ok) != 0 {
+		t.Errorf("Expected no remembered check, got %d", len(handler.checks.ok))
+	}
+	for i := 0; i < 2; i++ {
+		expectStatus("dent", http.StatusOK)
+		if len(handler.checks.ok) != 1 {
+			t.Errorf("Expected 1 remembered check, got %d", len(handler.checks.ok))
+		}
+	}
+	expectStatus("42", http.StatusUnauthorized)
+
+	// The checks are bound to the password hash.
+	if !handler.checks.compare("arthurdent", "dent", "$2a$04$N0dxXCyWrmCuxF1oWfYmPufFgUzfc4CRy6CzbbHYmdpGVd40oJIOa") {
+		t.Errorf("Expected the password to match the hash")
+	}
+	if handler.checks.compare("arthurdent", "dent", "$2a$04$invalid") {
+		t.Errorf("Expected the password not to match another hash")
+	}
+}
+
+func TestBasicAuthHandlerReload(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configPath := filepath.Join(tmpDir, "web.yml")
+	writeConfig := func(content string) {
+		if err := ioutil.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The password of all users is "dent".
+	writeConfig("basic_auth_users:\n  arthurdent: $2a$04$N0dxXCyWrmCuxF1oWfYmPufFgUzfc4CRy6CzbbHYmdpGVd40oJIOa\n")
+
+	cache, err := newConfigCache(configPath, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(&basicAuthHandler{
+		cache: cache,
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, ExpectedMessage)
+		}),
+		logger: log.NewNopLogger(),
+	})
+	defer server.Close()
+
+	expectStatus := func(user string, code int) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth(user, "dent")
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+		if r.StatusCode != code {
+			t.Errorf("%s: expected status code %d, got %d", user, code, r.StatusCode)
+		}
+	}
+	expectStatus("arthurdent", http.StatusOK)
+
+	// The last good configuration is kept while the file is invalid.
+	writeConfig("basic_auth_users:\n  arthurdent: dent\n")
+	expectStatus("arthurdent", http.StatusOK)
+
+	writeConfig("basic_auth_users:\n  zaphod: $2a$04$N0dxXCyWrmCuxF1oWfYmPufFgUzfc4CRy6CzbbHYmdpGVd40oJIOa\n")
+	expectStatus("arthurdent", http.StatusUnauthorized)
+	expectStatus("zaphod", http.StatusOK)
+}